@@ -1,12 +1,16 @@
 package algs
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"load-balancer/conf"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,21 +29,35 @@ type IBackendServer interface {
 	GetStatus() ServerStatus
 	GetID() uuid.UUID
 	GetUrl() string
+	GetTransport() http.RoundTripper
+	GetHealthCheck() conf.HealthCheckType
 	IncrementReqCount() int
 	SetWeight(weight int) error
 	GetWeight() int
+	Acquire() bool
+	Release()
+	ActiveConnections() int
+	AtCapacity() bool
 }
 type BackendServer struct {
-	ID          uuid.UUID
-	Host        string
-	Port        int
-	ReqCount    int
-	Weight      int
-	Status      ServerStatus
-	LastChecked time.Time
-	mu          sync.RWMutex
+	ID             uuid.UUID
+	Scheme         string
+	Host           string
+	Port           int
+	ReqCount       int
+	Weight         int
+	MaxConnections int
+	Zone           string
+	Transport      http.RoundTripper
+	HealthCheck    conf.HealthCheckType
+	Status         ServerStatus
+	LastChecked    time.Time
+	active         int
+	mu             sync.RWMutex
 }
 
+var ErrNoCapacity = errors.New("all servers are at capacity")
+
 func (s *BackendServer) SetStatus(status ServerStatus) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -47,13 +65,31 @@ func (s *BackendServer) SetStatus(status ServerStatus) error {
 	return nil
 }
 func (s *BackendServer) GetStatus() ServerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.Status
 }
 func (s *BackendServer) GetID() uuid.UUID {
 	return s.ID
 }
 func (s *BackendServer) GetUrl() string {
-	return fmt.Sprintf("http://%s:%d", s.Host, s.Port)
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, s.Host, s.Port)
+}
+
+// GetTransport returns the transport used for both proxied requests and health checks, so
+// pings see the same TLS settings as traffic.
+func (s *BackendServer) GetTransport() http.RoundTripper {
+	if s.Transport == nil {
+		return http.DefaultTransport
+	}
+	return s.Transport
+}
+func (s *BackendServer) GetHealthCheck() conf.HealthCheckType {
+	return s.HealthCheck
 }
 func (s *BackendServer) IncrementReqCount() int {
 	s.mu.Lock()
@@ -76,6 +112,33 @@ func (s *BackendServer) GetWeight() int {
 	return s.Weight
 }
 
+func (s *BackendServer) Acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConnections > 0 && s.active >= s.MaxConnections {
+		return false
+	}
+	s.active++
+	return true
+}
+func (s *BackendServer) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active > 0 {
+		s.active--
+	}
+}
+func (s *BackendServer) ActiveConnections() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+func (s *BackendServer) AtCapacity() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.MaxConnections > 0 && s.active >= s.MaxConnections
+}
+
 func NewBackendServer(host string, port int, weight int) *BackendServer {
 	return &BackendServer{
 		ID:          uuid.New(),
@@ -100,6 +163,8 @@ const (
 	Random             Alg = "Random"
 	RoundRobin         Alg = "RoundRobin"
 	WeightedRoundRobin Alg = "WeightedRoundRobin"
+	Hash               Alg = "Hash"
+	LeastConnections   Alg = "LeastConnections"
 )
 
 type AlgParams struct {
@@ -114,43 +179,163 @@ func AlgFactory(alg Alg, params AlgParams) (IAlgorithm, error) {
 		return NewRoundRobinAlgorithm(params)
 	case WeightedRoundRobin:
 		return NewWeightedRoundRobinAlgorithm(params)
+	case Hash:
+		return NewHashAlgorithm(params)
+	case LeastConnections:
+		return NewLeastConnectionsAlgorithm(params)
 	default:
 		return nil, errors.New("unsupported algorithm")
 	}
 }
 
+const pingTimeout = 5 * time.Second
+
 func Ping(server IBackendServer) error {
 	if os.Getenv("RUN_TYPE") == "test" {
 		return nil
 	}
-	url := fmt.Sprintf("%s/ping", server.GetUrl())
-	resp, err := http.Get(url)
+	if strings.HasPrefix(server.GetUrl(), "udp://") {
+		// UDP has no handshake, so there is nothing to check without knowing the protocol
+		return nil
+	}
+	if addr, isTCP := strings.CutPrefix(server.GetUrl(), "tcp://"); isTCP {
+		// stream backends may not speak HTTP, so a completed connect is all we check
+		conn, err := net.DialTimeout("tcp", addr, pingTimeout)
+		if err != nil {
+			return fmt.Errorf("connect failed: %w", err)
+		}
+		return conn.Close()
+	}
+	client := &http.Client{Transport: server.GetTransport(), Timeout: pingTimeout}
+	if server.GetHealthCheck() == conf.HealthCheckGRPC {
+		return pingGRPC(client, server.GetUrl())
+	}
+	resp, err := client.Get(fmt.Sprintf("%s/ping", server.GetUrl()))
 	if err != nil {
-		fmt.Println("Request failed:", err)
-		return err
+		return fmt.Errorf("ping failed: %w", err)
 	}
+	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Println("Failed to read response:", err)
-		return err
+		return fmt.Errorf("failed to read ping response: %w", err)
 	}
-	defer resp.Body.Close()
 	if string(body) != "Pong" {
-		fmt.Println("Unexpected response:", string(body))
+		return fmt.Errorf("unexpected ping response %q", body)
+	}
+	return nil
+}
+
+// grpcHealthServing is a grpc.health.v1.HealthCheckResponse with status SERVING.
+var grpcHealthServing = []byte{0x08, 0x01}
+
+// pingGRPC calls grpc.health.v1.Health/Check for the overall server status. The message
+// framing is simple enough to write by hand: a compression flag, a 4-byte length, and an empty
+// HealthCheckRequest.
+func pingGRPC(client *http.Client, baseURL string) error {
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/grpc.health.v1.Health/Check", bytes.NewReader(make([]byte, 5)))
+	if err != nil {
 		return err
 	}
-	return errors.New(":ping error")
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("grpc health check failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read grpc health response: %w", err)
+	}
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return fmt.Errorf("grpc health check returned status %q", status)
+	}
+	if len(body) < 5 || !bytes.Equal(body[5:], grpcHealthServing) {
+		return fmt.Errorf("grpc health check reports not serving")
+	}
+	return nil
 }
 
-func NewAlgorithm(loc *conf.LocationConf) (IAlgorithm, error) {
+// NewAlgorithm builds the location's backends; transport is shared with health checks and may be
+// nil for http.DefaultTransport. A backend's scheme overrides the location's. Backend IDs are
+// derived from their URL so they survive restarts and agree across balancer instances.
+// Backends declaring more than one priority tier are wrapped in a TieredAlgorithm, and each tier
+// prefers the location's zone when it has backends both in and outside of it.
+func NewAlgorithm(loc *conf.LocationConf, transport http.RoundTripper) (IAlgorithm, error) {
+	switch loc.HealthCheck {
+	case "", conf.HealthCheckHTTP:
+	case conf.HealthCheckGRPC:
+		// gRPC only runs over HTTP/2, which the location's transport only speaks with protocol: grpc
+		if loc.Protocol != conf.ProtocolGRPC {
+			return nil, errors.New("health_check grpc requires protocol grpc")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported health_check %q", loc.HealthCheck)
+	}
 	servers := make([]IBackendServer, 0, len(loc.BackendServers))
+	tiers := make(map[tierKey][]IBackendServer)
+	seen := make(map[string]int, len(loc.BackendServers))
 	for _, server := range loc.BackendServers {
-		servers = append(servers, NewBackendServer(server.Host, server.Port, server.Weight))
+		backend := NewBackendServer(server.Host, server.Port, server.Weight)
+		backend.MaxConnections = server.MaxConnections
+		backend.Transport = transport
+		backend.HealthCheck = loc.HealthCheck
+		backend.Zone = server.Zone
+		backend.Scheme = server.Scheme
+		if backend.Scheme == "" {
+			backend.Scheme = loc.Scheme
+		}
+		switch backend.Scheme {
+		case "", "http", "https", "tcp", "udp":
+		default:
+			return nil, fmt.Errorf("unsupported scheme %q for %s:%d", backend.Scheme, server.Host, server.Port)
+		}
+		name := backend.GetUrl()
+		if n := seen[name]; n > 0 {
+			name = fmt.Sprintf("%s#%d", name, n)
+		}
+		seen[backend.GetUrl()]++
+		backend.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(name))
+		servers = append(servers, backend)
+		key := tierKey{backup: server.Backup, priority: server.Priority}
+		tiers[key] = append(tiers[key], backend)
+	}
+	if len(tiers) > 1 {
+		keys := make([]tierKey, 0, len(tiers))
+		for key := range tiers {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].before(keys[j]) })
+		ordered := make([]IAlgorithm, 0, len(keys))
+		for _, key := range keys {
+			// every tier runs its own algorithm, so each keeps its own rotation and health checks
+			tier, err := NewZoneAwareAlgorithm(Alg(loc.Algorithm), tiers[key], loc.Zone, loc.ZoneMinAvailable)
+			if err != nil {
+				return nil, fmt.Errorf("error while selecting algorithm %v", err)
+			}
+			ordered = append(ordered, tier)
+		}
+		return NewTieredAlgorithm(ordered, loc.Overflow), nil
 	}
-	alg, err := AlgFactory(Alg(loc.Algorithm), AlgParams{
-		Servers: servers})
+	alg, err := NewZoneAwareAlgorithm(Alg(loc.Algorithm), servers, loc.Zone, loc.ZoneMinAvailable)
 	if err != nil {
 		return nil, fmt.Errorf("error while selecting algorithm %v", err)
 	}
 	return alg, nil
 }
+
+type tierKey struct {
+	backup   bool
+	priority int
+}
+
+func (k tierKey) before(other tierKey) bool {
+	if k.backup != other.backup {
+		return !k.backup
+	}
+	return k.priority < other.priority
+}
@@ -0,0 +1,99 @@
+package algs
+
+import (
+	"fmt"
+	"load-balancer/conf"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestPing_HTTPBackend(t *testing.T) {
+	t.Setenv("RUN_TYPE", "")
+	reply := "Pong"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, reply)
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	server := NewBackendServer(u.Hostname(), port, 1)
+	if err := Ping(server); err != nil {
+		t.Errorf("expected a Pong reply to pass: %v", err)
+	}
+	reply = "nope"
+	if err := Ping(server); err == nil {
+		t.Error("expected an unexpected reply to fail")
+	}
+}
+
+func TestPing_TCPBackend(t *testing.T) {
+	t.Setenv("RUN_TYPE", "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	server := NewBackendServer(addr.IP.String(), addr.Port, 1)
+	server.Scheme = "tcp"
+	if err := Ping(server); err != nil {
+		t.Errorf("expected connect check to pass: %v", err)
+	}
+	ln.Close()
+	if err := Ping(server); err == nil {
+		t.Error("expected connect check to fail on a closed port")
+	}
+}
+
+func TestNewAlgorithm_GRPCHealthCheckRequiresGRPCProtocol(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	loc := &conf.LocationConf{
+		Algorithm:      string(RoundRobin),
+		HealthCheck:    conf.HealthCheckGRPC,
+		BackendServers: []conf.BackendServer{{Host: "localhost", Port: 8080}},
+	}
+	if _, err := NewAlgorithm(loc, nil); err == nil {
+		t.Error("expected health_check grpc over HTTP/1.1 to be rejected")
+	}
+	loc.Protocol = conf.ProtocolGRPC
+	if _, err := NewAlgorithm(loc, nil); err != nil {
+		t.Errorf("expected health_check grpc with protocol grpc to be accepted: %v", err)
+	}
+}
+
+func TestHealthCheck_ConcurrentWithSelection(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	servers := []IBackendServer{NewBackendServer("localhost", 8080, 1), NewBackendServer("localhost", 8081, 1)}
+	rr, _ := NewRoundRobinAlgorithm(AlgParams{Servers: servers})
+	random, _ := NewRandomAlgorithm(AlgParams{Servers: servers})
+	hash, _ := NewHashAlgorithm(AlgParams{Servers: servers})
+	wrr, _ := NewWeightedRoundRobinAlgorithm(AlgParams{Servers: servers})
+	checks := map[string]struct {
+		alg         IAlgorithm
+		healthCheck func()
+	}{
+		"RoundRobin": {rr, rr.healthCheck},
+		"Random":     {random, random.healthCheck},
+		"Hash":       {hash, hash.healthCheck},
+		"Weighted":   {wrr, wrr.healthCheck},
+	}
+	for name, c := range checks {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for range 200 {
+					c.healthCheck()
+				}
+			}()
+			for range 200 {
+				c.alg.HealthyServers()
+				c.alg.NextServer()
+			}
+			<-done
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package algs
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+type IKeyedAlgorithm interface {
+	IAlgorithm
+	NextServerForKey(key string) (IBackendServer, error)
+}
+
+// HashAlgorithm maps a request key to a server with rendezvous hashing, so only the keys of a
+// server that becomes unhealthy move elsewhere.
+type HashAlgorithm struct {
+	Servers        []IBackendServer
+	orderedHealthy []IBackendServer
+	mu             sync.RWMutex
+	ticker         *time.Ticker
+}
+
+func (h *HashAlgorithm) AllServers() ([]IBackendServer, error) {
+	return h.Servers, nil
+}
+func (h *HashAlgorithm) HealthyServers() ([]IBackendServer, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]IBackendServer{}, h.orderedHealthy...), nil
+}
+
+// NextServer is used when a request carries no key and spreads those requests randomly.
+func (h *HashAlgorithm) NextServer() (IBackendServer, error) {
+	return h.NextServerForKey(fmt.Sprint(rand.Uint64()))
+}
+func (h *HashAlgorithm) NextServerForKey(key string) (IBackendServer, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.orderedHealthy) == 0 {
+		return nil, errors.New("no server available")
+	}
+	var best IBackendServer
+	var bestScore uint64
+	for _, server := range h.orderedHealthy {
+		if server.AtCapacity() {
+			continue
+		}
+		if score := rendezvousScore(key, server); best == nil || score > bestScore {
+			best, bestScore = server, score
+		}
+	}
+	if best == nil {
+		return nil, ErrNoCapacity
+	}
+	return best, nil
+}
+func (h *HashAlgorithm) healthCheck() {
+	healthy := make([]IBackendServer, 0, len(h.Servers))
+	for _, server := range h.Servers {
+		if err := Ping(server); err != nil {
+			fmt.Printf("Server %s is unhealthy: %v\n", server.GetUrl(), err)
+			server.SetStatus(UnHealthy)
+		} else {
+			server.SetStatus(Healthy)
+			healthy = append(healthy, server)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.orderedHealthy = healthy
+}
+
+func rendezvousScore(key string, server IBackendServer) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	id := server.GetID()
+	hash.Write(id[:])
+	return hash.Sum64()
+}
+
+func NewHashAlgorithm(params AlgParams) (*HashAlgorithm, error) {
+	orderedHealthy := make([]IBackendServer, 0, len(params.Servers))
+	for _, server := range params.Servers {
+		if server.GetStatus() == Healthy {
+			orderedHealthy = append(orderedHealthy, server)
+		}
+	}
+	alg := &HashAlgorithm{
+		Servers:        params.Servers,
+		orderedHealthy: orderedHealthy,
+		ticker:         time.NewTicker(time.Second * 30),
+	}
+	go func() {
+		for range alg.ticker.C {
+			fmt.Printf("[HashAlgorithm] health check at %v\n", time.Now())
+			alg.healthCheck()
+		}
+	}()
+	return alg, nil
+}
@@ -0,0 +1,54 @@
+package algs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestHashAlgorithm(t *testing.T) {
+	os.Setenv("RUN_TYPE", "test")
+	params := AlgParams{
+		Servers: []IBackendServer{
+			NewBackendServer("localhost", 8080, 1),
+			NewBackendServer("localhost", 8081, 1),
+			NewBackendServer("localhost", 8082, 1),
+		},
+	}
+	alg, err := NewHashAlgorithm(params)
+	if err != nil {
+		t.Fatalf("Failed to create HashAlgorithm: %v", err)
+	}
+
+	assigned := make(map[string]string)
+	used := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		server, err := alg.NextServerForKey(key)
+		if err != nil {
+			t.Fatalf("NextServerForKey failed: %v", err)
+		}
+		again, _ := alg.NextServerForKey(key)
+		if again.GetUrl() != server.GetUrl() {
+			t.Errorf("key %s mapped to %s and then %s", key, server.GetUrl(), again.GetUrl())
+		}
+		assigned[key] = server.GetUrl()
+		used[server.GetUrl()] = true
+	}
+	if len(used) != len(params.Servers) {
+		t.Errorf("expected keys spread over all servers, got %v", used)
+	}
+
+	removed := params.Servers[0]
+	removed.SetStatus(UnHealthy)
+	alg.orderedHealthy = params.Servers[1:]
+	for key, url := range assigned {
+		server, err := alg.NextServerForKey(key)
+		if err != nil {
+			t.Fatalf("NextServerForKey failed: %v", err)
+		}
+		if url != removed.GetUrl() && server.GetUrl() != url {
+			t.Errorf("key %s moved from healthy %s to %s", key, url, server.GetUrl())
+		}
+	}
+}
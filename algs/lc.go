@@ -0,0 +1,87 @@
+package algs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LeastConnectionsAlgorithm picks the healthy server with the fewest active connections,
+// including long-lived upgraded ones. Ties rotate so a burst of new connections spreads across
+// equally loaded servers instead of piling onto the first.
+type LeastConnectionsAlgorithm struct {
+	Servers        []IBackendServer
+	orderedHealthy []IBackendServer
+	offset         int
+	mu             sync.Mutex
+	ticker         *time.Ticker
+}
+
+func (l *LeastConnectionsAlgorithm) AllServers() ([]IBackendServer, error) {
+	return l.Servers, nil
+}
+func (l *LeastConnectionsAlgorithm) HealthyServers() ([]IBackendServer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]IBackendServer(nil), l.orderedHealthy...), nil
+}
+func (l *LeastConnectionsAlgorithm) NextServer() (IBackendServer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.orderedHealthy) == 0 {
+		return nil, errors.New("no server available")
+	}
+	l.offset = (l.offset + 1) % len(l.orderedHealthy)
+	var best IBackendServer
+	for i := range l.orderedHealthy {
+		server := l.orderedHealthy[(l.offset+i)%len(l.orderedHealthy)]
+		if server.AtCapacity() {
+			continue
+		}
+		if best == nil || server.ActiveConnections() < best.ActiveConnections() {
+			best = server
+		}
+	}
+	if best == nil {
+		return nil, ErrNoCapacity
+	}
+	return best, nil
+}
+func (l *LeastConnectionsAlgorithm) healthCheck() {
+	healthy := make([]IBackendServer, 0, len(l.Servers))
+	for _, server := range l.Servers {
+		if err := Ping(server); err != nil {
+			fmt.Printf("Server %s is unhealthy: %v\n", server.GetUrl(), err)
+			server.SetStatus(UnHealthy)
+		} else {
+			server.SetStatus(Healthy)
+			healthy = append(healthy, server)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.orderedHealthy = healthy
+}
+
+func NewLeastConnectionsAlgorithm(params AlgParams) (*LeastConnectionsAlgorithm, error) {
+	orderedHealthy := make([]IBackendServer, 0, len(params.Servers))
+	for _, server := range params.Servers {
+		if server.GetStatus() == Healthy {
+			orderedHealthy = append(orderedHealthy, server)
+		}
+	}
+	alg := &LeastConnectionsAlgorithm{
+		Servers:        params.Servers,
+		orderedHealthy: orderedHealthy,
+		offset:         -1,
+		ticker:         time.NewTicker(time.Second * 30),
+	}
+	go func() {
+		for range alg.ticker.C {
+			fmt.Printf("[LeastConnectionsAlgorithm] health check at %v\n", time.Now())
+			alg.healthCheck()
+		}
+	}()
+	return alg, nil
+}
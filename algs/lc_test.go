@@ -0,0 +1,43 @@
+package algs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLeastConnections(t *testing.T) {
+	os.Setenv("RUN_TYPE", "test")
+	a := NewBackendServer("localhost", 8080, 1)
+	b := NewBackendServer("localhost", 8081, 1)
+	c := NewBackendServer("localhost", 8082, 1)
+	alg, err := NewLeastConnectionsAlgorithm(AlgParams{Servers: []IBackendServer{a, b, c}})
+	if err != nil {
+		t.Fatalf("Failed to create LeastConnectionsAlgorithm: %v", err)
+	}
+
+	// a burst of connections should spread evenly while every server is equally loaded
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		server, err := alg.NextServer()
+		if err != nil {
+			t.Fatalf("NextServer returned error: %v", err)
+		}
+		server.Acquire()
+		counts[server.GetUrl()]++
+	}
+	for _, server := range []IBackendServer{a, b, c} {
+		if counts[server.GetUrl()] != 2 {
+			t.Errorf("expected 2 connections on %s, got %d", server.GetUrl(), counts[server.GetUrl()])
+		}
+	}
+
+	a.Release()
+	a.Release()
+	for i := 0; i < 2; i++ {
+		server, _ := alg.NextServer()
+		if server != a {
+			t.Errorf("expected the least loaded server %s, got %s", a.GetUrl(), server.GetUrl())
+		}
+		server.Acquire()
+	}
+}
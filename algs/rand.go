@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand/v2"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +13,7 @@ import (
 type RandomAlgorithm struct {
 	Servers        []IBackendServer
 	healthyServers map[uuid.UUID]IBackendServer
+	mu             sync.RWMutex
 	ticker         *time.Ticker
 }
 
@@ -19,6 +21,8 @@ func (r *RandomAlgorithm) AllServers() ([]IBackendServer, error) {
 	return r.Servers, nil
 }
 func (r *RandomAlgorithm) HealthyServers() ([]IBackendServer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	servers := make([]IBackendServer, 0, len(r.healthyServers))
 	for _, server := range r.healthyServers {
 		servers = append(servers, server)
@@ -26,29 +30,36 @@ func (r *RandomAlgorithm) HealthyServers() ([]IBackendServer, error) {
 	return servers, nil
 }
 func (r *RandomAlgorithm) NextServer() (IBackendServer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	if len(r.Servers) == 0 || len(r.healthyServers) == 0 {
 		return nil, errors.New("no servers available")
 	}
-	randIndex := rand.IntN(len(r.healthyServers))
-	healthyServers := make([]IBackendServer, 0, len(r.healthyServers))
+	available := make([]IBackendServer, 0, len(r.healthyServers))
 	for _, server := range r.healthyServers {
-		healthyServers = append(healthyServers, server)
+		if !server.AtCapacity() {
+			available = append(available, server)
+		}
+	}
+	if len(available) == 0 {
+		return nil, ErrNoCapacity
 	}
-	return healthyServers[randIndex], nil
+	return available[rand.IntN(len(available))], nil
 }
 func (r *RandomAlgorithm) healthCheck() {
+	healthy := make(map[uuid.UUID]IBackendServer, len(r.Servers))
 	for _, server := range r.Servers {
 		if err := Ping(server); err != nil {
 			fmt.Printf("Server %s is unhealthy: %v\n", server.GetUrl(), err)
 			server.SetStatus(UnHealthy)
-			delete(r.healthyServers, server.GetID())
 		} else {
 			server.SetStatus(Healthy)
-			if _, exists := r.healthyServers[server.GetID()]; !exists {
-				r.healthyServers[server.GetID()] = server
-			}
+			healthy[server.GetID()] = server
 		}
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthyServers = healthy
 }
 
 func NewRandomAlgorithm(params AlgParams) (*RandomAlgorithm, error) {
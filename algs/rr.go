@@ -22,6 +22,8 @@ func (r *RoundRobinAlgorithm) AllServers() ([]IBackendServer, error) {
 	return r.Servers, nil
 }
 func (r *RoundRobinAlgorithm) HealthyServers() ([]IBackendServer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	servers := make([]IBackendServer, 0, len(r.healthyServers))
 	for _, server := range r.healthyServers {
 		servers = append(servers, server)
@@ -34,8 +36,13 @@ func (r *RoundRobinAlgorithm) NextServer() (IBackendServer, error) {
 	if len(r.orderedHealthy) == 0 {
 		return nil, errors.New("no server available")
 	}
-	r.CurrentIndex = (r.CurrentIndex + 1) % len(r.healthyServers)
-	return r.orderedHealthy[r.CurrentIndex], nil
+	for range r.orderedHealthy {
+		r.CurrentIndex = (r.CurrentIndex + 1) % len(r.orderedHealthy)
+		if server := r.orderedHealthy[r.CurrentIndex]; !server.AtCapacity() {
+			return server, nil
+		}
+	}
+	return nil, ErrNoCapacity
 }
 func (r *RoundRobinAlgorithm) healthCheck() {
 	r.mu.Lock()
@@ -52,6 +59,7 @@ func (r *RoundRobinAlgorithm) healthCheck() {
 			if _, exists := r.healthyServers[server.GetID()]; !exists {
 				r.healthyServers[server.GetID()] = server
 			}
+			r.orderedHealthy = append(r.orderedHealthy, server)
 		}
 	}
 }
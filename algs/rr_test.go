@@ -1,6 +1,7 @@
 package algs
 
 import (
+	"errors"
 	"os"
 	"testing"
 )
@@ -56,3 +57,36 @@ func TestRoundRobin(t *testing.T) {
 		}
 	}
 }
+
+func TestRoundRobin_SkipsServersAtCapacity(t *testing.T) {
+	os.Setenv("RUN_TYPE", "test")
+	full := NewBackendServer("localhost", 8080, 1)
+	full.MaxConnections = 1
+	free := NewBackendServer("localhost", 8081, 1)
+	alg, err := NewRoundRobinAlgorithm(AlgParams{Servers: []IBackendServer{full, free}})
+	if err != nil {
+		t.Fatalf("Failed to create RoundRobinAlgorithm: %v", err)
+	}
+	if !full.Acquire() {
+		t.Fatal("Acquire should succeed below max connections")
+	}
+	if full.Acquire() {
+		t.Fatal("Acquire should fail at max connections")
+	}
+	for i := 0; i < 3; i++ {
+		server, err := alg.NextServer()
+		if err != nil || server.GetUrl() != free.GetUrl() {
+			t.Errorf("expected server below capacity %s, got %v, error: %v", free.GetUrl(), server, err)
+		}
+	}
+
+	free.MaxConnections = 1
+	free.Acquire()
+	if _, err := alg.NextServer(); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("expected ErrNoCapacity when every server is full, got %v", err)
+	}
+	full.Release()
+	if server, err := alg.NextServer(); err != nil || server.GetUrl() != full.GetUrl() {
+		t.Errorf("expected released server %s, got %v, error: %v", full.GetUrl(), server, err)
+	}
+}
@@ -0,0 +1,75 @@
+package algs
+
+import "errors"
+
+// TieredAlgorithm sends traffic to the first tier that has a healthy server, e.g. a primary site
+// before its disaster recovery standby. With Overflow it also moves on while every healthy server
+// of a tier is at capacity; without it such requests wait for the tier.
+type TieredAlgorithm struct {
+	Tiers    []IAlgorithm
+	Overflow bool
+}
+
+func (t *TieredAlgorithm) AllServers() ([]IBackendServer, error) {
+	var servers []IBackendServer
+	for _, tier := range t.Tiers {
+		tierServers, _ := tier.AllServers()
+		servers = append(servers, tierServers...)
+	}
+	return servers, nil
+}
+func (t *TieredAlgorithm) HealthyServers() ([]IBackendServer, error) {
+	var servers []IBackendServer
+	for _, tier := range t.Tiers {
+		healthy, _ := tier.HealthyServers()
+		servers = append(servers, healthy...)
+	}
+	return servers, nil
+}
+func (t *TieredAlgorithm) NextServer() (IBackendServer, error) {
+	return t.next(func(tier IAlgorithm) (IBackendServer, error) {
+		return tier.NextServer()
+	})
+}
+func (t *TieredAlgorithm) NextServerForKey(key string) (IBackendServer, error) {
+	return t.next(func(tier IAlgorithm) (IBackendServer, error) {
+		if keyed, ok := tier.(IKeyedAlgorithm); ok {
+			return keyed.NextServerForKey(key)
+		}
+		return tier.NextServer()
+	})
+}
+
+func (t *TieredAlgorithm) next(pick func(IAlgorithm) (IBackendServer, error)) (IBackendServer, error) {
+	err := errors.New("no server available")
+	for _, tier := range t.Tiers {
+		if healthy, _ := tier.HealthyServers(); len(healthy) == 0 {
+			continue
+		}
+		server, tierErr := pick(tier)
+		if tierErr == nil {
+			return server, nil
+		}
+		// a tier that is full waits for capacity unless overflowing, any other failure moves on
+		if !t.Overflow && errors.Is(tierErr, ErrNoCapacity) {
+			return nil, tierErr
+		}
+		err = tierErr
+	}
+	return nil, err
+}
+
+// ActiveTier returns the tier taking traffic, the first with a healthy server, or nil when no
+// tier has one.
+func (t *TieredAlgorithm) ActiveTier() IAlgorithm {
+	for _, tier := range t.Tiers {
+		if healthy, _ := tier.HealthyServers(); len(healthy) > 0 {
+			return tier
+		}
+	}
+	return nil
+}
+
+func NewTieredAlgorithm(tiers []IAlgorithm, overflow bool) *TieredAlgorithm {
+	return &TieredAlgorithm{Tiers: tiers, Overflow: overflow}
+}
@@ -0,0 +1,100 @@
+package algs
+
+import (
+	"errors"
+	"load-balancer/conf"
+	"net"
+	"testing"
+)
+
+func TestTieredAlgorithm_FailsOverByPriority(t *testing.T) {
+	for _, alg := range []Alg{RoundRobin, WeightedRoundRobin} {
+		t.Run(string(alg), func(t *testing.T) {
+			testTieredFailover(t, alg)
+		})
+	}
+}
+
+func testTieredFailover(t *testing.T, algorithm Alg) {
+	t.Setenv("RUN_TYPE", "")
+	var listeners []net.Listener
+	var backends []conf.BackendServer
+	for range 3 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		defer ln.Close()
+		listeners = append(listeners, ln)
+		backends = append(backends, conf.BackendServer{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, Weight: 1})
+	}
+	backends[0].Backup = true
+	backends[2].Priority = 1
+	alg, err := NewAlgorithm(&conf.LocationConf{Algorithm: string(algorithm), Scheme: "tcp", BackendServers: backends}, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	tiered, ok := alg.(*TieredAlgorithm)
+	if !ok || len(tiered.Tiers) != 3 {
+		t.Fatalf("expected three tiers, got %T", alg)
+	}
+
+	expect := func(want conf.BackendServer) {
+		t.Helper()
+		for range 3 {
+			server, err := alg.NextServer()
+			if err != nil {
+				t.Fatalf("NextServer returned error: %v", err)
+			}
+			if server.(*BackendServer).Port != want.Port {
+				t.Fatalf("expected port %d, got %s", want.Port, server.GetUrl())
+			}
+		}
+	}
+	healthCheck := func() {
+		for _, tier := range tiered.Tiers {
+			tier.(interface{ healthCheck() }).healthCheck()
+		}
+	}
+
+	expect(backends[1])
+	listeners[1].Close()
+	healthCheck()
+	expect(backends[2])
+	listeners[2].Close()
+	healthCheck()
+	expect(backends[0])
+	listeners[0].Close()
+	healthCheck()
+	if _, err := alg.NextServer(); err == nil {
+		t.Error("expected an error with every tier down")
+	}
+}
+
+func TestTieredAlgorithm_Overflow(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	backends := []conf.BackendServer{
+		{Host: "localhost", Port: 8001, MaxConnections: 1},
+		{Host: "localhost", Port: 8002, Backup: true},
+	}
+	for _, overflow := range []bool{false, true} {
+		alg, err := NewAlgorithm(&conf.LocationConf{Algorithm: string(RoundRobin), Overflow: overflow, BackendServers: backends}, nil)
+		if err != nil {
+			t.Fatalf("failed to create algorithm: %v", err)
+		}
+		primary, _ := alg.NextServer()
+		if !primary.Acquire() {
+			t.Fatal("failed to acquire the primary")
+		}
+		server, err := alg.NextServer()
+		if !overflow {
+			if !errors.Is(err, ErrNoCapacity) {
+				t.Errorf("expected ErrNoCapacity without overflow, got %v", err)
+			}
+			continue
+		}
+		if err != nil || server.(*BackendServer).Port != 8002 {
+			t.Errorf("expected overflow to the backup, got %v, %v", server, err)
+		}
+	}
+}
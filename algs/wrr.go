@@ -21,7 +21,16 @@ func (r *WeightedRoundRobinAlgorithm) AllServers() ([]IBackendServer, error) {
 	return r.Servers, nil
 }
 func (r *WeightedRoundRobinAlgorithm) HealthyServers() ([]IBackendServer, error) {
-	return r.Servers, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// orderedHealthy repeats servers by weight, so report each healthy server once
+	healthy := make([]IBackendServer, 0, len(r.healthyServers))
+	for _, server := range r.Servers {
+		if _, ok := r.healthyServers[server.GetID()]; ok {
+			healthy = append(healthy, server)
+		}
+	}
+	return healthy, nil
 }
 func (r *WeightedRoundRobinAlgorithm) NextServer() (IBackendServer, error) {
 	r.mu.Lock()
@@ -29,29 +38,32 @@ func (r *WeightedRoundRobinAlgorithm) NextServer() (IBackendServer, error) {
 	if len(r.orderedHealthy) == 0 {
 		return nil, errors.New("no server available")
 	}
-	r.CurrentIndex = (r.CurrentIndex + 1) % len(r.orderedHealthy)
-	return r.orderedHealthy[r.CurrentIndex], nil
+	for range r.orderedHealthy {
+		r.CurrentIndex = (r.CurrentIndex + 1) % len(r.orderedHealthy)
+		if server := r.orderedHealthy[r.CurrentIndex]; !server.AtCapacity() {
+			return server, nil
+		}
+	}
+	return nil, ErrNoCapacity
 }
 func (r *WeightedRoundRobinAlgorithm) healthCheck() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.healthyServers = make(map[uuid.UUID]IBackendServer)
-	r.orderedHealthy = r.orderedHealthy[:0]
+	healthyServers := make(map[uuid.UUID]IBackendServer)
+	orderedHealthy := make([]IBackendServer, 0, len(r.Servers))
 	for _, server := range r.Servers {
 		if err := Ping(server); err != nil {
 			server.SetStatus(UnHealthy)
-			delete(r.healthyServers, server.GetID())
 		} else {
 			server.SetStatus(Healthy)
-			if _, exists := r.healthyServers[server.GetID()]; !exists {
-				r.healthyServers[server.GetID()] = server
-			}
-			weight := server.GetWeight()
-			for i := 0; i < weight; i++ {
-				r.orderedHealthy = append(r.orderedHealthy, server)
+			healthyServers[server.GetID()] = server
+			for i := 0; i < server.GetWeight(); i++ {
+				orderedHealthy = append(orderedHealthy, server)
 			}
 		}
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthyServers = healthyServers
+	r.orderedHealthy = orderedHealthy
 }
 
 func NewWeightedRoundRobinAlgorithm(params AlgParams) (*WeightedRoundRobinAlgorithm, error) {
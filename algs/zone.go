@@ -0,0 +1,119 @@
+package algs
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+const defaultZoneMinAvailable = 70
+
+// ZoneAwareAlgorithm keeps traffic in the balancer's zone while enough local servers are
+// available, like Envoy's zone-aware routing. Below MinAvailable percent of local servers being
+// healthy and under capacity, the local share shrinks in proportion and the rest goes to Remote.
+type ZoneAwareAlgorithm struct {
+	Local        IAlgorithm
+	Remote       IAlgorithm
+	LocalServers []IBackendServer
+	MinAvailable float64
+}
+
+func (z *ZoneAwareAlgorithm) AllServers() ([]IBackendServer, error) {
+	local, _ := z.Local.AllServers()
+	remote, _ := z.Remote.AllServers()
+	return append(append([]IBackendServer{}, local...), remote...), nil
+}
+func (z *ZoneAwareAlgorithm) HealthyServers() ([]IBackendServer, error) {
+	local, _ := z.Local.HealthyServers()
+	remote, _ := z.Remote.HealthyServers()
+	return append(append([]IBackendServer{}, local...), remote...), nil
+}
+func (z *ZoneAwareAlgorithm) NextServer() (IBackendServer, error) {
+	return z.next(rand.Float64(), func(alg IAlgorithm) (IBackendServer, error) {
+		return alg.NextServer()
+	})
+}
+
+// NextServerForKey chooses the zone from the key rather than at random, so a key keeps mapping
+// to one backend while traffic spills.
+func (z *ZoneAwareAlgorithm) NextServerForKey(key string) (IBackendServer, error) {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	roll := float64(hash.Sum64()>>11) / (1 << 53)
+	return z.next(roll, func(alg IAlgorithm) (IBackendServer, error) {
+		if keyed, ok := alg.(IKeyedAlgorithm); ok {
+			return keyed.NextServerForKey(key)
+		}
+		return alg.NextServer()
+	})
+}
+
+// next prefers the local side when roll, in [0, 1), is below the local share. It falls back to
+// the other side when the preferred one cannot serve, so a zone outage never turns into errors
+// while the other zones have room.
+func (z *ZoneAwareAlgorithm) next(roll float64, pick func(IAlgorithm) (IBackendServer, error)) (IBackendServer, error) {
+	first, second := z.Local, z.Remote
+	if roll >= z.localShare() {
+		first, second = second, first
+	}
+	server, err := pick(first)
+	if err == nil {
+		return server, nil
+	}
+	server, fallbackErr := pick(second)
+	if fallbackErr == nil {
+		return server, nil
+	}
+	if errors.Is(fallbackErr, ErrNoCapacity) {
+		return nil, fallbackErr
+	}
+	return nil, err
+}
+
+// localShare is the fraction of requests kept in the local zone. It reads each server's status,
+// which health checks keep current, rather than copying the local algorithm's healthy list on
+// every request.
+func (z *ZoneAwareAlgorithm) localShare() float64 {
+	if len(z.LocalServers) == 0 {
+		return 0
+	}
+	available := 0
+	for _, server := range z.LocalServers {
+		if server.GetStatus() == Healthy && !server.AtCapacity() {
+			available++
+		}
+	}
+	percent := float64(available) * 100 / float64(len(z.LocalServers))
+	return min(percent/z.MinAvailable, 1)
+}
+
+// NewZoneAwareAlgorithm runs alg separately over the servers in zone and the rest. It returns a
+// plain algorithm when either side would be empty, since there is nothing to prefer.
+func NewZoneAwareAlgorithm(alg Alg, servers []IBackendServer, zone string, minAvailable float64) (IAlgorithm, error) {
+	if minAvailable > 100 {
+		return nil, errors.New("zone_min_available_percent cannot exceed 100")
+	}
+	if minAvailable <= 0 {
+		minAvailable = defaultZoneMinAvailable
+	}
+	var local, remote []IBackendServer
+	for _, server := range servers {
+		if backend, ok := server.(*BackendServer); ok && zone != "" && backend.Zone == zone {
+			local = append(local, server)
+		} else {
+			remote = append(remote, server)
+		}
+	}
+	if len(local) == 0 || len(remote) == 0 {
+		return AlgFactory(alg, AlgParams{Servers: servers})
+	}
+	localAlg, err := AlgFactory(alg, AlgParams{Servers: local})
+	if err != nil {
+		return nil, err
+	}
+	remoteAlg, err := AlgFactory(alg, AlgParams{Servers: remote})
+	if err != nil {
+		return nil, err
+	}
+	return &ZoneAwareAlgorithm{Local: localAlg, Remote: remoteAlg, LocalServers: local, MinAvailable: minAvailable}, nil
+}
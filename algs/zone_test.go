@@ -0,0 +1,142 @@
+package algs
+
+import (
+	"errors"
+	"load-balancer/conf"
+	"testing"
+)
+
+func newZonedLocation(local, remote int) *conf.LocationConf {
+	loc := &conf.LocationConf{Algorithm: string(RoundRobin), Zone: "eu-west-1a"}
+	for i := range local {
+		loc.BackendServers = append(loc.BackendServers, conf.BackendServer{Host: "local", Port: 8000 + i, MaxConnections: 1, Zone: "eu-west-1a"})
+	}
+	for i := range remote {
+		loc.BackendServers = append(loc.BackendServers, conf.BackendServer{Host: "remote", Port: 9000 + i, MaxConnections: 1, Zone: "eu-west-1b"})
+	}
+	return loc
+}
+
+func localFraction(t *testing.T, alg IAlgorithm, picks int) float64 {
+	t.Helper()
+	local := 0
+	for range picks {
+		server, err := alg.NextServer()
+		if err != nil {
+			t.Fatalf("NextServer returned error: %v", err)
+		}
+		if server.(*BackendServer).Zone == "eu-west-1a" {
+			local++
+		}
+	}
+	return float64(local) / float64(picks)
+}
+
+func TestZoneAwareAlgorithm(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	alg, err := NewAlgorithm(newZonedLocation(4, 4), nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	zoned, ok := alg.(*ZoneAwareAlgorithm)
+	if !ok {
+		t.Fatalf("expected a ZoneAwareAlgorithm, got %T", alg)
+	}
+	if got := localFraction(t, alg, 100); got != 1 {
+		t.Errorf("expected every request in the local zone, got %.2f", got)
+	}
+
+	// half of the local servers are full, below the default 70% threshold
+	zoned.LocalServers[0].Acquire()
+	zoned.LocalServers[1].Acquire()
+	if got := localFraction(t, alg, 4000); got < 0.64 || got > 0.79 {
+		t.Errorf("expected about 71%% of requests in the local zone, got %.2f", got)
+	}
+
+	zoned.LocalServers[2].Acquire()
+	zoned.LocalServers[3].Acquire()
+	if got := localFraction(t, alg, 100); got != 0 {
+		t.Errorf("expected every request in other zones once the local zone is full, got %.2f", got)
+	}
+	remote, _ := zoned.Remote.AllServers()
+	for _, server := range remote {
+		server.Acquire()
+	}
+	if _, err := alg.NextServer(); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("expected ErrNoCapacity with every zone full, got %v", err)
+	}
+}
+
+func TestNewZoneAwareAlgorithm_FallsBackWithoutZones(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	loc := newZonedLocation(2, 0)
+	if alg, _ := NewAlgorithm(loc, nil); alg == nil {
+		t.Fatal("failed to create algorithm")
+	} else if _, zoned := alg.(*ZoneAwareAlgorithm); zoned {
+		t.Error("expected a plain algorithm when every backend is local")
+	}
+	loc = newZonedLocation(2, 2)
+	loc.Zone = ""
+	if alg, _ := NewAlgorithm(loc, nil); alg == nil {
+		t.Fatal("failed to create algorithm")
+	} else if _, zoned := alg.(*ZoneAwareAlgorithm); zoned {
+		t.Error("expected a plain algorithm without a local zone")
+	}
+	loc = newZonedLocation(2, 2)
+	loc.ZoneMinAvailable = 150
+	if _, err := NewAlgorithm(loc, nil); err == nil {
+		t.Error("expected error for zone_min_available_percent over 100")
+	}
+}
+
+func TestZoneAwareAlgorithm_SpillsWhenLocalServersUnhealthy(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	loc := newZonedLocation(4, 4)
+	loc.Algorithm = string(WeightedRoundRobin)
+	for i := range loc.BackendServers {
+		loc.BackendServers[i].Weight = 1
+	}
+	alg, err := NewAlgorithm(loc, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	zoned := alg.(*ZoneAwareAlgorithm)
+	zoned.LocalServers[0].SetStatus(UnHealthy)
+	zoned.LocalServers[1].SetStatus(UnHealthy)
+	if got := localFraction(t, alg, 4000); got < 0.64 || got > 0.79 {
+		t.Errorf("expected about 71%% of requests in the local zone, got %.2f", got)
+	}
+}
+
+func TestZoneAwareAlgorithm_KeyStaysOnOneBackendWhileSpilling(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	loc := newZonedLocation(4, 4)
+	loc.Algorithm = string(Hash)
+	alg, err := NewAlgorithm(loc, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	zoned := alg.(*ZoneAwareAlgorithm)
+	zoned.LocalServers[0].SetStatus(UnHealthy)
+	zoned.LocalServers[1].SetStatus(UnHealthy)
+	if share := zoned.localShare(); share >= 1 {
+		t.Fatalf("expected traffic to spill, local share is %.2f", share)
+	}
+
+	zones := map[string]bool{}
+	for _, key := range []string{"10.0.0.7", "10.0.0.8", "10.0.0.9", "user-1", "user-2", "user-3"} {
+		first, err := zoned.NextServerForKey(key)
+		if err != nil {
+			t.Fatalf("NextServerForKey returned error: %v", err)
+		}
+		zones[first.(*BackendServer).Zone] = true
+		for range 50 {
+			if server, _ := zoned.NextServerForKey(key); server != first {
+				t.Fatalf("key %q moved from %s to %s", key, first.GetUrl(), server.GetUrl())
+			}
+		}
+	}
+	if len(zones) != 2 {
+		t.Errorf("expected keys to spread over both zones, got %v", zones)
+	}
+}
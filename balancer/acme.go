@@ -0,0 +1,152 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const defaultACMECacheDir = "acme-certs"
+
+// acmeManager returns the shared ACME manager, creating it on first use. Certificates are cached
+// on disk and renewed in the background renew_before their expiry (30 days by default).
+func (b *Balancer) acmeManager() (*autocert.Manager, error) {
+	if b.acme != nil {
+		return b.acme, nil
+	}
+	c := b.conf.ACME
+	client := &acme.Client{DirectoryURL: c.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if c.DirectoryCA != "" {
+		pem, err := os.ReadFile(c.DirectoryCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME directory CA: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ACME directory CA %s", c.DirectoryCA)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	cacheDir := c.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultACMECacheDir
+	}
+	b.acmeHosts = make(map[string]bool)
+	b.acme = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cacheDir),
+		HostPolicy:  b.acmeHostPolicy,
+		Email:       c.Email,
+		RenewBefore: c.RenewBefore,
+		Client:      client,
+	}
+	return b.acme, nil
+}
+
+// addACMEHost allows certificates for host. ACME HTTP-01 and TLS-ALPN-01 cannot validate
+// wildcard names, so only exact hosts may use tls: auto.
+func (b *Balancer) addACMEHost(host string) error {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" || strings.ContainsAny(host, "*~") || strings.HasPrefix(host, ".") {
+		return fmt.Errorf("tls: auto needs an exact host name, got %q", host)
+	}
+	b.acmeHosts[host] = true
+	return nil
+}
+
+func (b *Balancer) acmeHostPolicy(_ context.Context, host string) error {
+	if !b.acmeHosts[strings.ToLower(host)] {
+		return fmt.Errorf("host %q is not configured with tls: auto", host)
+	}
+	return nil
+}
+
+// isACMEChallenge reports whether hello is a TLS-ALPN-01 validation connection, which must be
+// answered with the challenge certificate and without asking for a client certificate.
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// acmeChallengeHandler answers HTTP-01 challenges on the configured port, passing everything else
+// to next.
+func (b *Balancer) acmeChallengeHandler(port int, next http.Handler) http.Handler {
+	if b.acme == nil || b.conf.ACME.HTTPChallengePort != port {
+		return next
+	}
+	return b.acme.HTTPHandler(next)
+}
+
+// serveACMEChallenges listens for HTTP-01 challenges when no proxy already serves plain HTTP on
+// the challenge port.
+func (b *Balancer) serveACMEChallenges() error {
+	port := b.conf.ACME.HTTPChallengePort
+	if b.acme == nil || port == 0 {
+		return nil
+	}
+	if _, exists := b.hostRouter[port]; exists {
+		for _, proxy := range b.getProxiesByPort(port) {
+			if proxy.TLS.Enabled() {
+				return fmt.Errorf("acme http_challenge_port %d is a TLS port", port)
+			}
+		}
+		return nil
+	}
+	go func() {
+		addr := fmt.Sprintf(":%d", port)
+		b.logger.Info(fmt.Sprintf("Serving ACME HTTP-01 challenges on %s", addr))
+		if err := http.ListenAndServe(addr, b.acme.HTTPHandler(nil)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.logger.Error(fmt.Sprintf("ACME challenge server error on port %d: %v", port, err))
+		}
+	}()
+	return nil
+}
+
+// prefetchACMECertificates obtains certificates at startup rather than on the first handshake.
+// Once loaded, the manager keeps renewing them in the background.
+func (b *Balancer) prefetchACMECertificates() {
+	if b.acme == nil {
+		return
+	}
+	for host := range b.acmeHosts {
+		go func(host string) {
+			cert, err := b.acme.GetCertificate(prefetchHello(host))
+			if err != nil {
+				b.logger.Warn(fmt.Sprintf("Failed to obtain ACME certificate for %s: %v", host, err))
+				return
+			}
+			if cert.Leaf != nil {
+				b.logger.Info(fmt.Sprintf("ACME certificate for %s valid until %s", host, cert.Leaf.NotAfter.Format(time.RFC3339)))
+			}
+		}(host)
+	}
+}
+
+// prefetchHello looks like a modern client, so the prefetched certificate is the ECDSA one that
+// autocert serves to most handshakes rather than its RSA fallback.
+func prefetchHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       host,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+}
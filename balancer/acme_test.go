@@ -0,0 +1,137 @@
+package balancer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"load-balancer/conf"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestACME_HostPolicy(t *testing.T) {
+	b := newTestBalancer(t)
+	b.conf.ACME.CacheDir = t.TempDir()
+	b.conf.Proxies = []conf.ProxyConf{{Port: 8443, Host: "Auto.Example.com", TLS: conf.TLSAuto}}
+	if err := b.registerProxy(b.conf.Proxies[0]); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	if err := b.acme.HostPolicy(context.Background(), "auto.example.com"); err != nil {
+		t.Errorf("expected configured host to be allowed: %v", err)
+	}
+	if err := b.acme.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("expected unknown host to be rejected")
+	}
+
+	for _, host := range []string{"*.example.com", "~^api", ".example.com"} {
+		if err := b.registerProxy(conf.ProxyConf{Port: 9443, Host: host, TLS: conf.TLSAuto}); err == nil {
+			t.Errorf("expected tls: auto to be rejected for %s", host)
+		}
+	}
+	if err := b.registerProxy(conf.ProxyConf{Port: 9444, Host: "x.example.com", TLS: "maybe"}); err == nil {
+		t.Error("expected unsupported tls mode to be rejected")
+	}
+}
+
+func TestACME_RebuildDoesNotRaceHostPolicy(t *testing.T) {
+	b := newTestBalancer(t)
+	b.conf.ACME.CacheDir = t.TempDir()
+	proxy := conf.ProxyConf{Port: 8443, Host: "auto.example.com", TLS: conf.TLSAuto}
+	if err := b.registerProxy(proxy); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			if _, err := b.buildTLSConfig(proxy); err != nil {
+				t.Errorf("failed to rebuild TLS config: %v", err)
+				return
+			}
+		}
+	}()
+	for range 100 {
+		if err := b.acme.HostPolicy(context.Background(), "auto.example.com"); err != nil {
+			t.Fatalf("expected configured host to be allowed: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestACME_TLSALPNChallengeSkipsClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	b := newTestBalancer(t)
+	b.conf.ACME.CacheDir = t.TempDir()
+	b.conf.Proxies = []conf.ProxyConf{{Port: 8443, Host: "auto.example.com", TLS: conf.TLSAuto, ClientCA: ca.path}}
+	if err := b.registerProxy(b.conf.Proxies[0]); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	serverConfig, err := b.portTLSConfig(8443, b.hostRouter[8443])
+	if err != nil {
+		t.Fatalf("portTLSConfig failed: %v", err)
+	}
+
+	regular, _ := serverConfig.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "auto.example.com", SupportedProtos: []string{"h2"}})
+	if regular.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected regular handshakes to require client certs, got %v", regular.ClientAuth)
+	}
+	challenge, _ := serverConfig.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "auto.example.com", SupportedProtos: []string{acme.ALPNProto}})
+	if challenge.ClientAuth != tls.NoClientCert {
+		t.Errorf("expected TLS-ALPN-01 handshakes without client auth, got %v", challenge.ClientAuth)
+	}
+}
+
+func TestACME_PrefetchUsesECDSACertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certPath, keyPath := ca.issue(t, dir, "auto.example.com", time.Now().Add(90*24*time.Hour))
+	b := newTestBalancer(t)
+	b.conf.ACME = conf.ACMEConf{DirectoryURL: "http://127.0.0.1:1/dir", CacheDir: t.TempDir()}
+	if err := b.registerProxy(conf.ProxyConf{Port: 8443, Host: "auto.example.com", TLS: conf.TLSAuto}); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	// autocert caches ECDSA certificates under the bare host name and RSA ones under host+rsa
+	keyPEM, _ := os.ReadFile(keyPath)
+	certPEM, _ := os.ReadFile(certPath)
+	if err := os.WriteFile(filepath.Join(b.conf.ACME.CacheDir, "auto.example.com"), append(keyPEM, certPEM...), 0600); err != nil {
+		t.Fatalf("failed to seed the cache: %v", err)
+	}
+	cert, err := b.acme.GetCertificate(prefetchHello("auto.example.com"))
+	if err != nil {
+		t.Fatalf("expected the cached ECDSA certificate without a new issuance: %v", err)
+	}
+	if _, ok := cert.PrivateKey.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("expected an ECDSA key, got %T", cert.PrivateKey)
+	}
+}
+
+// TestACME_Pebble issues a certificate from a local Pebble server started with
+// PEBBLE_VA_ALWAYS_VALID=1, e.g. ACME_DIRECTORY_URL=https://localhost:14000/dir and
+// ACME_DIRECTORY_CA=pebble.minica.pem.
+func TestACME_Pebble(t *testing.T) {
+	directory := os.Getenv("ACME_DIRECTORY_URL")
+	if directory == "" {
+		t.Skip("ACME_DIRECTORY_URL not set")
+	}
+	b := newTestBalancer(t)
+	b.conf.ACME = conf.ACMEConf{DirectoryURL: directory, DirectoryCA: os.Getenv("ACME_DIRECTORY_CA"), CacheDir: t.TempDir()}
+	if err := b.registerProxy(conf.ProxyConf{Port: 8443, Host: "pebble.example.com", TLS: conf.TLSAuto}); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	cert, err := b.acme.GetCertificate(&tls.ClientHelloInfo{ServerName: "pebble.example.com"})
+	if err != nil {
+		t.Fatalf("failed to obtain certificate: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.VerifyHostname("pebble.example.com") != nil {
+		t.Errorf("expected a certificate for pebble.example.com")
+	}
+	entries, _ := os.ReadDir(b.conf.ACME.CacheDir)
+	if len(entries) == 0 {
+		t.Error("expected the certificate to be stored on disk")
+	}
+}
@@ -0,0 +1,227 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"load-balancer/conf"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveAIMD     = "aimd"
+	adaptiveGradient = "gradient"
+
+	defaultInitialLimit     = 20
+	defaultMinLimit         = 1
+	defaultMaxLimit         = 1000
+	defaultBackoffRatio     = 0.9
+	defaultLatencyThreshold = time.Second
+	defaultSmoothing        = 0.2
+	defaultRTTDecay         = 0.05
+	adaptiveLogInterval     = 5 * time.Second
+)
+
+// adaptiveLimiter learns a location's safe concurrency from observed latency, in the spirit of
+// Netflix concurrency-limits. Requests beyond the current limit are shed.
+type adaptiveLimiter struct {
+	algorithm        string
+	minLimit         float64
+	maxLimit         float64
+	backoffRatio     float64
+	latencyThreshold time.Duration
+	smoothing        float64
+	onChange         func(limit int, log bool)
+
+	mu        sync.Mutex
+	limit     float64
+	inflight  int
+	longRTT   float64
+	lastLog   time.Time
+	lastLimit int
+}
+
+func newAdaptiveLimiter(c *conf.AdaptiveConcurrencyConf, onChange func(limit int, log bool)) (*adaptiveLimiter, error) {
+	if c == nil {
+		return nil, nil
+	}
+	l := &adaptiveLimiter{
+		algorithm:        c.Algorithm,
+		minLimit:         float64(c.MinLimit),
+		maxLimit:         float64(c.MaxLimit),
+		limit:            float64(c.InitialLimit),
+		backoffRatio:     c.BackoffRatio,
+		latencyThreshold: c.LatencyThreshold,
+		smoothing:        c.Smoothing,
+		onChange:         onChange,
+	}
+	switch l.algorithm {
+	case "":
+		l.algorithm = adaptiveAIMD
+	case adaptiveAIMD, adaptiveGradient:
+	default:
+		return nil, fmt.Errorf("unsupported adaptive concurrency algorithm %q", c.Algorithm)
+	}
+	if l.minLimit <= 0 {
+		l.minLimit = defaultMinLimit
+	}
+	if l.maxLimit <= 0 {
+		l.maxLimit = defaultMaxLimit
+	}
+	if l.minLimit > l.maxLimit {
+		return nil, errors.New("adaptive concurrency min_limit exceeds max_limit")
+	}
+	if l.limit <= 0 {
+		l.limit = defaultInitialLimit
+	}
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.limit))
+	if l.backoffRatio <= 0 || l.backoffRatio >= 1 {
+		l.backoffRatio = defaultBackoffRatio
+	}
+	if l.latencyThreshold <= 0 {
+		l.latencyThreshold = defaultLatencyThreshold
+	}
+	if l.smoothing <= 0 || l.smoothing > 1 {
+		l.smoothing = defaultSmoothing
+	}
+	l.lastLimit = int(l.limit)
+	return l, nil
+}
+
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// release records the outcome of a request; dropped marks failures that signal overload.
+func (l *adaptiveLimiter) release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	inflight := l.inflight
+	l.inflight--
+	switch l.algorithm {
+	case adaptiveGradient:
+		l.updateGradient(rtt, dropped, inflight)
+	default:
+		l.updateAIMD(rtt, dropped, inflight)
+	}
+	limit := int(l.limit)
+	changed := limit != l.lastLimit
+	shouldLog := changed && time.Since(l.lastLog) >= adaptiveLogInterval
+	if changed {
+		l.lastLimit = limit
+	}
+	if shouldLog {
+		l.lastLog = time.Now()
+	}
+	l.mu.Unlock()
+
+	if changed && l.onChange != nil {
+		l.onChange(limit, shouldLog)
+	}
+}
+
+func (l *adaptiveLimiter) updateAIMD(rtt time.Duration, dropped bool, inflight int) {
+	if dropped || rtt > l.latencyThreshold {
+		l.limit = math.Max(l.minLimit, l.limit*l.backoffRatio)
+		return
+	}
+	// only grow when the limit is actually being used, otherwise it would drift up while idle
+	if float64(inflight)*2 >= l.limit {
+		l.limit = math.Min(l.maxLimit, l.limit+1)
+	}
+}
+
+func (l *adaptiveLimiter) updateGradient(rtt time.Duration, dropped bool, inflight int) {
+	sample := float64(rtt)
+	if sample <= 0 {
+		return
+	}
+	if l.longRTT == 0 {
+		l.longRTT = sample
+	} else {
+		l.longRTT = l.longRTT*(1-defaultRTTDecay) + sample*defaultRTTDecay
+	}
+	if dropped {
+		l.limit = math.Max(l.minLimit, l.limit*l.backoffRatio)
+		return
+	}
+	if float64(inflight)*2 < l.limit {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1.0, l.longRTT/sample))
+	target := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.limit*(1-l.smoothing) + target*l.smoothing
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.limit))
+}
+
+// forget gives back a slot without a sample, for requests whose duration says nothing about
+// backend latency.
+func (l *adaptiveLimiter) forget() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+}
+
+// adaptiveSlot is one request's share of a limit. It is given back once: early for upgrades and
+// gRPC streams, whose length is the session's rather than the backend's, or when the request ends.
+// The RTT runs from backend acquisition, so time spent queueing is not counted as latency.
+type adaptiveSlot struct {
+	limiter *adaptiveLimiter
+	start   time.Time
+	once    sync.Once
+}
+
+func (s *adaptiveSlot) started() {
+	if s != nil {
+		s.start = time.Now()
+	}
+}
+
+func (s *adaptiveSlot) release(dropped bool) {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		var rtt time.Duration
+		if !s.start.IsZero() {
+			rtt = time.Since(s.start)
+		}
+		s.limiter.release(rtt, dropped)
+	})
+}
+
+func (s *adaptiveSlot) forget() {
+	if s != nil {
+		s.once.Do(s.limiter.forget)
+	}
+}
+
+func (l *adaptiveLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (b *Balancer) newLocationAdaptiveLimiter(name string, c *conf.AdaptiveConcurrencyConf) (*adaptiveLimiter, error) {
+	if c == nil {
+		return nil, nil
+	}
+	gauge := b.metrics.Gauge("balancer_adaptive_concurrency_limit", "location", name)
+	limiter, err := newAdaptiveLimiter(c, func(limit int, log bool) {
+		gauge.Set(float64(limit))
+		if log {
+			b.logger.Info(fmt.Sprintf("Adaptive concurrency limit for %s is now %d", name, limit))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	gauge.Set(float64(limiter.currentLimit()))
+	return limiter, nil
+}
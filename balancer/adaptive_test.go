@@ -0,0 +1,123 @@
+package balancer
+
+import (
+	"context"
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter_AIMD(t *testing.T) {
+	var changes []int
+	l, err := newAdaptiveLimiter(&conf.AdaptiveConcurrencyConf{
+		Algorithm:        adaptiveAIMD,
+		InitialLimit:     4,
+		MaxLimit:         5,
+		LatencyThreshold: 100 * time.Millisecond,
+		BackoffRatio:     0.5,
+	}, func(limit int, log bool) { changes = append(changes, limit) })
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if !l.acquire() {
+			t.Fatalf("acquire %d should succeed under the limit", i)
+		}
+	}
+	if l.acquire() {
+		t.Fatal("acquire beyond the limit should be shed")
+	}
+
+	l.release(10*time.Millisecond, false)
+	if got := l.currentLimit(); got != 5 {
+		t.Errorf("expected additive increase to 5, got %d", got)
+	}
+	l.release(10*time.Millisecond, false)
+	if got := l.currentLimit(); got != 5 {
+		t.Errorf("limit must not exceed max_limit, got %d", got)
+	}
+	l.release(time.Second, false)
+	if got := l.currentLimit(); got != 2 {
+		t.Errorf("expected multiplicative decrease on slow response to 2, got %d", got)
+	}
+	l.release(10*time.Millisecond, true)
+	if got := l.currentLimit(); got != 1 {
+		t.Errorf("expected multiplicative decrease on dropped request to 1, got %d", got)
+	}
+	if len(changes) != 3 || changes[len(changes)-1] != 1 {
+		t.Errorf("expected limit changes to be reported, got %v", changes)
+	}
+}
+
+func TestAdaptiveLimiter_GradientBacksOffOnLatency(t *testing.T) {
+	l, err := newAdaptiveLimiter(&conf.AdaptiveConcurrencyConf{
+		Algorithm:    adaptiveGradient,
+		InitialLimit: 10,
+		Smoothing:    1,
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+	sample := func(rtt time.Duration) {
+		for i := 0; i < l.currentLimit(); i++ {
+			l.acquire()
+		}
+		l.release(rtt, false)
+		for l.inflight > 0 {
+			l.inflight--
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		sample(10 * time.Millisecond)
+	}
+	grown := l.currentLimit()
+	if grown <= 10 {
+		t.Fatalf("expected limit to grow under steady latency, got %d", grown)
+	}
+	for i := 0; i < 5; i++ {
+		sample(100 * time.Millisecond)
+	}
+	if got := l.currentLimit(); got >= grown {
+		t.Errorf("expected limit to shrink when latency rises, %d -> %d", grown, got)
+	}
+}
+
+func TestNewAdaptiveLimiter_Validation(t *testing.T) {
+	if l, err := newAdaptiveLimiter(nil, nil); l != nil || err != nil {
+		t.Errorf("expected no limiter without config, got %v, %v", l, err)
+	}
+	if _, err := newAdaptiveLimiter(&conf.AdaptiveConcurrencyConf{Algorithm: "vegas"}, nil); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+	if _, err := newAdaptiveLimiter(&conf.AdaptiveConcurrencyConf{MinLimit: 10, MaxLimit: 5}, nil); err == nil {
+		t.Error("expected error when min_limit exceeds max_limit")
+	}
+}
+
+func TestProxyRequest_QueueFullBacksOffAdaptiveLimit(t *testing.T) {
+	l, err := newAdaptiveLimiter(&conf.AdaptiveConcurrencyConf{Algorithm: adaptiveAIMD, InitialLimit: 4, BackoffRatio: 0.5}, nil)
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+	a := newTestAdmission(t, &conf.LocationConf{
+		Algorithm:      "RoundRobin",
+		BackendServers: []conf.BackendServer{{Host: "localhost", Port: 8001, MaxConnections: 1}},
+	})
+	if _, err := a.acquire(context.Background(), "", nil); err != nil {
+		t.Fatalf("failed to fill the backend: %v", err)
+	}
+	handler := &routeHandler{Path: "/", Admission: a, Adaptive: l}
+
+	w := httptest.NewRecorder()
+	newTestBalancer(t).proxyRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "example.com", "/", handler, nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with the backend full, got %d", w.Code)
+	}
+	if got := l.currentLimit(); got != 2 {
+		t.Errorf("expected the 503 to count as a drop and halve the limit, got %d", got)
+	}
+}
@@ -1,165 +1,537 @@
 package balancer
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"load-balancer/algs"
 	"load-balancer/conf"
 	"load-balancer/log"
+	"load-balancer/metrics"
+	"load-balancer/ratelimit"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type IBalancer interface {
 	Start() error
+	Shutdown(ctx context.Context) error
 }
 type Balancer struct {
 	conf       *conf.Conf
 	logger     log.ILogger
-	hostRouter map[int]map[string][]*routeHandler
+	hostRouter map[int]*hostMatcher
+	rateStore  ratelimit.IStore
+	metrics    *metrics.Registry
+	acme       *autocert.Manager
+	acmeHosts  map[string]bool
+
+	certReloaders []*certReloader
+	udpProxies    map[int]*udpProxy
+	splits        map[string]*trafficSplit
+
+	mu         sync.Mutex
+	servers    []shutdowner
+	listeners  []io.Closer
+	upgradesMu sync.Mutex
+	upgrades   map[*upgradedConn]struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
 }
 type routeHandler struct {
-	Path string
-	Alg  algs.IAlgorithm
+	Path       string
+	Alg        algs.IAlgorithm
+	Transport  http.RoundTripper
+	RateLimits []*rateLimiter
+	Admission  *admission
+	Adaptive   *adaptiveLimiter
+	Rewrite    string
+	SetHeaders map[string]string
+	HashKey    string
+	Match      *requestMatcher
+	Sticky     *stickySessions
+	Split      *trafficSplit
+	Mirror     *mirror
+
+	RequireClientCert bool
+	ClientCertHeaders conf.ClientCertHeadersConf
+
+	// WebSocket, when set, requires (true) or refuses (false) WebSocket upgrades.
+	WebSocket         *bool
+	WebSocketIdle     time.Duration
+	WebSocketLifetime time.Duration
 }
 
 func (b *Balancer) Start() error {
+	store, err := newRateLimitStore(b.conf.RateLimitStore)
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit store: %w", err)
+	}
+	b.rateStore = store
+	if status := b.conf.UnmatchedHostStatus; status != 0 && (status < 400 || status > 599) {
+		return fmt.Errorf("unmatched_host_status %d is not an error status", status)
+	}
+	if b.conf.Metrics.Port > 0 {
+		go b.serveMetrics()
+	}
 	for _, proxy := range b.conf.Proxies {
 		if err := b.registerProxy(proxy); err != nil {
 			return fmt.Errorf("failed to register proxy for host %s: %w", proxy.Host, err)
 		}
 	}
+	if err := b.serveACMEChallenges(); err != nil {
+		return err
+	}
+	reloadInterval := b.conf.CertReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultCertReloadInterval
+	}
+	for _, reloader := range b.certReloaders {
+		go reloader.watch(reloadInterval)
+	}
+	for _, udp := range b.udpProxies {
+		go udp.listenAndServe()
+	}
+	if len(b.splits) > 0 {
+		conf.OnChange(b.reloadSplits)
+	}
 
-	for port, hostMap := range b.hostRouter {
-		proxyConf, ok := b.getProxyByPort(port)
-		if !ok {
-			b.logger.Error(fmt.Sprintf("No proxy configuration found for port %d", port))
+	for port, hosts := range b.hostRouter {
+		proxyProtocol, err := b.portProxyProtocol(port)
+		if err != nil {
+			return err
+		}
+		mode, err := b.portMode(port)
+		if err != nil {
+			return err
+		}
+		if mode.IsStream() {
+			go b.serveStream(port, hosts, mode, proxyProtocol)
 			continue
 		}
+		tlsConfig, err := b.portTLSConfig(port, hosts)
+		if err != nil {
+			return err
+		}
+		http3Enabled, err := b.portHTTP3(port, tlsConfig)
+		if err != nil {
+			return err
+		}
 
-		go func(port int, hostMap map[string][]*routeHandler, proxy conf.ProxyConf) {
+		go func(port int, hosts *hostMatcher, proxyProtocol bool, tlsConfig *tls.Config) {
 			addr := fmt.Sprintf(":%d", port)
 
-			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				b.routeRequest(w, r, port, hostMap)
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b.routeRequest(w, r, port, hosts)
 			})
+			if http3Enabled {
+				h3 := b.newHTTP3Server(port, tlsConfig, handler)
+				go b.serveHTTP3(h3, port)
+				handler = withAltSvc(h3, handler)
+			}
+			handler = b.acmeChallengeHandler(port, handler)
 
+			// HTTP/2 is negotiated over ALPN on TLS ports and accepted with prior knowledge (h2c)
+			// on plaintext ports, which is how gRPC clients connect
+			var protocols http.Protocols
+			protocols.SetHTTP1(true)
+			protocols.SetHTTP2(true)
+			protocols.SetUnencryptedHTTP2(true)
 			server := &http.Server{
-				Addr:    addr,
-				Handler: handler,
+				Addr:      addr,
+				Handler:   handler,
+				TLSConfig: tlsConfig,
+				Protocols: &protocols,
 			}
+			b.trackServer(server)
 
-			if proxy.TLS {
-				b.logger.Info(fmt.Sprintf("Listening with TLS on %s", addr))
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				b.logger.Error(fmt.Sprintf("Failed to listen on port %d: %v", port, err))
+				return
+			}
+			if proxyProtocol {
+				b.logger.Info(fmt.Sprintf("Accepting PROXY protocol headers on %s", addr))
+				ln = newProxyProtoListener(ln, proxyHeaderTimeout)
+			}
 
-				tlsConfig, err := b.buildTLSConfig(proxy)
-				if err != nil {
-					b.logger.Error(fmt.Sprintf("Failed to build TLS config for port %d: %v", port, err))
-					return
-				}
-				server.TLSConfig = tlsConfig
+			if tlsConfig != nil {
+				b.logger.Info(fmt.Sprintf("Listening with TLS on %s", addr))
 
-				if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				if err := server.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 					b.logger.Error(fmt.Sprintf("HTTPS server error on port %d: %v", port, err))
 				}
 			} else {
 				b.logger.Info(fmt.Sprintf("Listening on %s", addr))
 
-				if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 					b.logger.Error(fmt.Sprintf("HTTP server error on port %d: %v", port, err))
 				}
 			}
-		}(port, hostMap, proxyConf)
+		}(port, hosts, proxyProtocol, tlsConfig)
 	}
+	b.prefetchACMECertificates()
 
-	select {}
+	<-b.done
+	return nil
 }
-func (b *Balancer) getProxyByPort(port int) (conf.ProxyConf, bool) {
+
+// getProxiesByPort returns the proxies sharing a TCP listener; udp proxies have their own socket.
+func (b *Balancer) getProxiesByPort(port int) []conf.ProxyConf {
+	proxies := make([]conf.ProxyConf, 0, len(b.conf.Proxies))
 	for _, proxy := range b.conf.Proxies {
-		if proxy.Port == port {
-			return proxy, true
+		if proxy.Port == port && proxy.Mode != conf.ModeUDP {
+			proxies = append(proxies, proxy)
 		}
 	}
-	return conf.ProxyConf{}, false
+	return proxies
 }
 
-func (b *Balancer) buildTLSConfig(proxy conf.ProxyConf) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(proxy.Certificate, proxy.CertificateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load server cert/key: %w", err)
-	}
-
-	caCertPool := x509.NewCertPool()
-	caCert, err := os.ReadFile(proxy.ClientCA)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read client CA cert: %w", err)
+// portProxyProtocol checks that every proxy sharing a listener agrees on PROXY protocol.
+func (b *Balancer) portProxyProtocol(port int) (bool, error) {
+	proxies := b.getProxiesByPort(port)
+	for _, proxy := range proxies[1:] {
+		if proxy.ProxyProtocol != proxies[0].ProxyProtocol {
+			return false, fmt.Errorf("proxies on port %d disagree on proxy_protocol", port)
+		}
 	}
-	caCertPool.AppendCertsFromPEM(caCert)
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    caCertPool,
-		MinVersion:   tls.VersionTLS12,
-	}, nil
+	return proxies[0].ProxyProtocol, nil
 }
 
 func (b *Balancer) registerProxy(proxy conf.ProxyConf) error {
+	if proxy.Mode == conf.ModeUDP {
+		return b.registerUDPProxy(proxy)
+	}
 	if _, exists := b.hostRouter[proxy.Port]; !exists {
-		b.hostRouter[proxy.Port] = make(map[string][]*routeHandler)
+		b.hostRouter[proxy.Port] = newHostMatcher()
+	}
+	proxyName := fmt.Sprintf("%s:%d", proxy.Host, proxy.Port)
+	proxyLimiter, err := newRateLimiter(proxyName, proxy.RateLimit, b.rateStore)
+	if err != nil {
+		return err
 	}
 
-	for _, loc := range proxy.Locations {
-		alg, err := algs.NewAlgorithm(&loc)
+	vhost, err := b.hostRouter[proxy.Port].add(proxy.Host, proxy.Default)
+	if err != nil {
+		return err
+	}
+	switch proxyMode(proxy) {
+	case conf.ModeHTTP:
+	case conf.ModeTLSPassthrough, conf.ModeTCP:
+		return b.registerStreamProxy(vhost, proxy)
+	default:
+		return fmt.Errorf("unsupported mode %q", proxy.Mode)
+	}
+	switch proxy.TLS {
+	case "", conf.TLSOff, conf.TLSOn, conf.TLSAuto:
+	default:
+		return fmt.Errorf("unsupported tls mode %q", proxy.TLS)
+	}
+	if proxy.TLS.Enabled() && vhost.tls == nil {
+		// ACME hosts are only added here, before serving, since the manager reads them on handshakes
+		if proxy.TLS == conf.TLSAuto {
+			if _, err := b.acmeManager(); err != nil {
+				return fmt.Errorf("failed to build TLS config: %w", err)
+			}
+			if err := b.addACMEHost(proxy.Host); err != nil {
+				return fmt.Errorf("failed to build TLS config: %w", err)
+			}
+		}
+		reloader, err := b.newCertReloader(proxy)
+		if err != nil {
+			return fmt.Errorf("failed to build TLS config: %w", err)
+		}
+		vhost.tls = reloader
+		if b.hostRouter[proxy.Port].fallbackTLS == nil {
+			b.hostRouter[proxy.Port].fallbackTLS = reloader
+		}
+		b.certReloaders = append(b.certReloaders, reloader)
+	}
+	rt := vhost.router
+	for i, loc := range proxy.Locations {
+		if loc.Zone == "" {
+			loc.Zone = b.conf.Zone
+		}
+		transport, err := newUpstreamTransport(&loc)
+		if err != nil {
+			return fmt.Errorf("transport error on path %s: %w", loc.Path, err)
+		}
+		if loc.UpstreamTLS != nil && loc.UpstreamTLS.InsecureSkipVerify {
+			b.logger.Warn(fmt.Sprintf("Upstream certificates are not verified for %s%s", proxyName, loc.Path))
+		}
+		alg, err := algs.NewAlgorithm(&loc, transport)
 		if err != nil {
 			return fmt.Errorf("algorithm error on path %s: %w", loc.Path, err)
 		}
-		b.hostRouter[proxy.Port][proxy.Host] = append(b.hostRouter[proxy.Port][proxy.Host], &routeHandler{
-			Path: loc.Path,
-			Alg:  alg,
-		})
+		locLimiter, err := newRateLimiter(proxyName+loc.Path, loc.RateLimit, b.rateStore)
+		if err != nil {
+			return fmt.Errorf("rate limit error on path %s: %w", loc.Path, err)
+		}
+		matcher, err := newRequestMatcher(loc.Match)
+		if err != nil {
+			return fmt.Errorf("match error on path %s: %w", loc.Path, err)
+		}
+		if loc.RequireClientCert {
+			authType, err := clientAuthMode(proxy)
+			if err != nil {
+				return err
+			}
+			if !proxy.TLS.Enabled() || !verifiesClientCerts(authType) {
+				return fmt.Errorf("require_client_cert on path %s needs TLS with client_auth verify_if_given or require_and_verify", loc.Path)
+			}
+		}
+		split, err := newTrafficSplit(&loc, transport)
+		if err != nil {
+			return fmt.Errorf("groups error on path %s: %w", loc.Path, err)
+		}
+		servers, _ := alg.AllServers()
+		if split != nil {
+			key := splitKey(proxyName, i, loc.Path)
+			if _, exists := b.splits[key]; exists {
+				return fmt.Errorf("groups on path %s are declared twice for %s", loc.Path, proxyName)
+			}
+			b.splits[key] = split
+			servers = split.servers()
+		}
+		mirror, err := newMirror(&loc, transport)
+		if err != nil {
+			return fmt.Errorf("mirror error on path %s: %w", loc.Path, err)
+		}
+		sticky, err := newStickySessions(loc.Sticky, servers)
+		if err != nil {
+			return fmt.Errorf("sticky error on path %s: %w", loc.Path, err)
+		}
+		adaptive, err := b.newLocationAdaptiveLimiter(proxyName+loc.Path, loc.AdaptiveConcurrency)
+		if err != nil {
+			return fmt.Errorf("adaptive concurrency error on path %s: %w", loc.Path, err)
+		}
+		if err := rt.add(loc.Path, &routeHandler{
+			Path:       loc.Path,
+			Alg:        alg,
+			Transport:  transport,
+			RateLimits: []*rateLimiter{proxyLimiter, locLimiter},
+			Admission:  newAdmission(&loc, alg),
+			Adaptive:   adaptive,
+			Rewrite:    loc.Rewrite,
+			SetHeaders: loc.SetHeaders,
+			HashKey:    loc.HashKey,
+			Match:      matcher,
+			Sticky:     sticky,
+			Split:      split,
+			Mirror:     mirror,
+
+			RequireClientCert: loc.RequireClientCert,
+			ClientCertHeaders: proxy.ClientCertHeaders,
+			WebSocket:         loc.WebSocket,
+			WebSocketIdle:     loc.WebSocketIdle,
+			WebSocketLifetime: loc.WebSocketLifetime,
+		}); err != nil {
+			return fmt.Errorf("invalid location %s: %w", loc.Path, err)
+		}
+	}
+	for _, warning := range rt.warnings {
+		b.logger.Warn(fmt.Sprintf("Ambiguous route on %s: %s", proxyName, warning))
 	}
+	rt.warnings = nil
 	return nil
 }
-func (b *Balancer) routeRequest(w http.ResponseWriter, r *http.Request, port int, hostMap map[string][]*routeHandler) {
+func (b *Balancer) routeRequest(w http.ResponseWriter, r *http.Request, port int, hosts *hostMatcher) {
 	host := normalizeHost(r.Host)
-	handlers, exists := hostMap[host]
-	if !exists {
-		http.Error(w, "host not found", http.StatusBadGateway)
+	vhost := hosts.match(host)
+	if vhost == nil {
+		status := b.conf.UnmatchedHostStatus
+		if status == 0 {
+			status = http.StatusNotFound
+		}
+		http.Error(w, "host not found", status)
 		b.logger.Warn(fmt.Sprintf("No routes registered for host %s on port %d", host, port))
 		return
 	}
-	cleanPath := path.Clean(r.URL.Path)
-	for _, handler := range handlers {
-		if strings.HasPrefix(cleanPath, handler.Path) {
-			server, err := handler.Alg.NextServer()
-			if err != nil {
-				http.Error(w, "backend unavailable", http.StatusBadGateway)
-				b.logger.Error(fmt.Sprintf("No backend for %s%s: %v", host, handler.Path, err))
-				return
-			}
-			target, err := url.Parse(server.GetUrl())
-			if err != nil {
-				http.Error(w, "invalid backend url", http.StatusInternalServerError)
-				b.logger.Error(fmt.Sprintf("Invalid backend URL %s: %v", server.GetUrl(), err))
-				return
-			}
-			b.logger.Info(fmt.Sprintf("[%s] %s %s -> %s", host, r.Method, cleanPath, server.GetUrl()))
-			httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
-			return
-		}
+	// the handshake must have used this host's config, otherwise a client could skip its client
+	// certificate policy by sending another host's SNI, or none at all
+	if r.TLS != nil && (r.TLS.ServerName != "" && hosts.match(r.TLS.ServerName) != vhost || hosts.tlsFor(r.TLS.ServerName) != vhost.tls) {
+		http.Error(w, "misdirected request", http.StatusMisdirectedRequest)
+		b.logger.Warn(fmt.Sprintf("Host %s does not match TLS server name %s on port %d", host, r.TLS.ServerName, port))
+		return
+	}
+	rt := vhost.router
+	cleanPath := path.Clean("/" + r.URL.Path)
+	if handler, params := rt.match(cleanPath, r); handler != nil {
+		b.proxyRequest(w, r, host, cleanPath, handler, params)
+		return
 	}
 
 	http.Error(w, "no matching route", http.StatusNotFound)
 	b.logger.Warn(fmt.Sprintf("No matching path for %s%s", host, cleanPath))
 }
 
+func (b *Balancer) proxyRequest(w http.ResponseWriter, r *http.Request, host, cleanPath string, handler *routeHandler, params map[string]string) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if handler.RequireClientCert && !hasVerifiedClientCert(r) {
+		http.Error(recorder, "client certificate required", http.StatusForbidden)
+		b.logger.Warn(fmt.Sprintf("Rejected %s%s without a verified client certificate", host, cleanPath))
+		return
+	}
+	upgrade := upgradeType(r)
+	if handler.WebSocket != nil {
+		if *handler.WebSocket && upgrade != "websocket" {
+			recorder.Header().Set("Upgrade", "websocket")
+			http.Error(recorder, "websocket upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		if !*handler.WebSocket && upgrade == "websocket" {
+			http.Error(recorder, "websocket not allowed", http.StatusForbidden)
+			b.logger.Warn(fmt.Sprintf("Refused websocket upgrade for %s%s", host, cleanPath))
+			return
+		}
+	}
+	if !b.applyRateLimits(recorder, r, handler.RateLimits...) {
+		return
+	}
+	var slot *adaptiveSlot
+	if handler.Adaptive != nil {
+		if !handler.Adaptive.acquire() {
+			http.Error(recorder, "service overloaded", http.StatusServiceUnavailable)
+			b.metrics.Counter("balancer_adaptive_concurrency_shed_total", "location", host+handler.Path).Inc()
+			return
+		}
+		slot = &adaptiveSlot{limiter: handler.Adaptive}
+		defer func() { slot.release(recorder.status >= http.StatusInternalServerError) }()
+	}
+	var hashKey string
+	if handler.HashKey != "" {
+		hashKey = expandTemplate(handler.HashKey, params)
+	}
+	admission, pinned := handler.Admission, handler.Sticky.pinned(r)
+	if handler.Split != nil {
+		group := handler.Split.pick(r, pinned)
+		if !group.has(pinned) {
+			pinned = nil
+		}
+		admission = group.admission
+		b.metrics.Counter("balancer_split_requests_total", "location", host+handler.Path, "group", group.name).Inc()
+	}
+	if pinned != nil && !inActiveTier(admission.alg, pinned) {
+		pinned = nil
+	}
+	server, err := admission.acquire(r.Context(), hashKey, pinned)
+	if err != nil {
+		if errors.Is(err, algs.ErrNoCapacity) || errors.Is(err, errQueueFull) || errors.Is(err, errQueueTimeout) {
+			http.Error(recorder, "service overloaded", http.StatusServiceUnavailable)
+			b.logger.Warn(fmt.Sprintf("Backends at capacity for %s%s: %v", host, handler.Path, err))
+			return
+		}
+		http.Error(recorder, "backend unavailable", http.StatusBadGateway)
+		b.logger.Error(fmt.Sprintf("No backend for %s%s: %v", host, handler.Path, err))
+		return
+	}
+	defer admission.release(server)
+	slot.started()
+	target, err := url.Parse(server.GetUrl())
+	if err != nil {
+		http.Error(recorder, "invalid backend url", http.StatusInternalServerError)
+		b.logger.Error(fmt.Sprintf("Invalid backend URL %s: %v", server.GetUrl(), err))
+		return
+	}
+	b.logger.Info(fmt.Sprintf("[%s] %s %s -> %s", host, r.Method, cleanPath, server.GetUrl()))
+	handler.Sticky.pin(recorder, r, server)
+	var rw http.ResponseWriter = recorder
+	if upgrade != "" {
+		rw = &upgradeWriter{
+			ResponseWriter: recorder,
+			b:              b,
+			websocket:      upgrade == "websocket",
+			idleTimeout:    handler.WebSocketIdle,
+			maxLifetime:    handler.WebSocketLifetime,
+			onHijack:       slot.forget,
+		}
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = handler.Transport
+	if isGRPC(r) {
+		proxy.FlushInterval = -1
+		proxy.ErrorHandler = b.grpcErrorHandler
+		// a stream can stay open for as long as the client likes, so only time the response headers
+		recorder.onHeader = func(status int) { slot.release(status >= http.StatusInternalServerError) }
+	}
+	out := handler.outgoingRequest(r, params)
+	if upgrade == "" {
+		b.mirrorRequest(handler.Mirror, host+handler.Path, out)
+	}
+	proxy.ServeHTTP(rw, out)
+	if isGRPC(r) {
+		b.recordGRPCStatus(host, handler.Path, r.URL.Path, recorder.Header())
+	}
+}
+
+// Shutdown stops accepting connections, lets in-flight requests finish and drains upgraded
+// connections until ctx is done. Start returns once it completes.
+func (b *Balancer) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	servers, listeners := b.servers, b.listeners
+	b.mu.Unlock()
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}()
+	}
+	b.drainUpgrades(ctx)
+	wg.Wait()
+	b.stopOnce.Do(func() { close(b.done) })
+	return errors.Join(errs...)
+}
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+func (b *Balancer) trackServer(server shutdowner) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.servers = append(b.servers, server)
+}
+
+func (b *Balancer) trackListener(ln io.Closer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, ln)
+}
+
+func (h *routeHandler) outgoingRequest(r *http.Request, params map[string]string) *http.Request {
+	out := r.WithContext(withClientAddrs(r.Context(), r.RemoteAddr))
+	if h.Rewrite != "" {
+		rewritten := *r.URL
+		rewritten.Path = expandTemplate(h.Rewrite, params)
+		rewritten.RawPath = ""
+		out.URL = &rewritten
+	}
+	if len(h.SetHeaders) > 0 || h.ClientCertHeaders != (conf.ClientCertHeadersConf{}) {
+		out.Header = r.Header.Clone()
+		setClientCertHeaders(out.Header, h.ClientCertHeaders, r.TLS)
+		for name, value := range h.SetHeaders {
+			out.Header.Set(name, expandTemplate(value, params))
+		}
+	}
+	return out
+}
+
 func normalizeHost(raw string) string {
 	if strings.Contains(raw, ":") {
 		host, _, err := net.SplitHostPort(raw)
@@ -173,6 +545,11 @@ func NewBalancer(conf *conf.Conf, logger log.ILogger) IBalancer {
 	return &Balancer{
 		conf:       conf,
 		logger:     logger,
-		hostRouter: make(map[int]map[string][]*routeHandler),
+		hostRouter: make(map[int]*hostMatcher),
+		udpProxies: make(map[int]*udpProxy),
+		splits:     make(map[string]*trafficSplit),
+		upgrades:   make(map[*upgradedConn]struct{}),
+		done:       make(chan struct{}),
+		metrics:    metrics.Default,
 	}
 }
@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"load-balancer/conf"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCertReloadInterval = 10 * time.Second
+	certExpiryWarning         = 14 * 24 * time.Hour
+	certExpiryWarningInterval = 24 * time.Hour
+)
+
+// certReloader serves a proxy's TLS config and rebuilds it when the certificate, key or client CA
+// files change on disk. A config that fails to build is rejected and the previous one stays in
+// service.
+type certReloader struct {
+	b           *Balancer
+	proxy       conf.ProxyConf
+	name        string
+	config      atomic.Pointer[tls.Config]
+	stamps      string
+	lastWarning time.Time
+}
+
+func (b *Balancer) newCertReloader(proxy conf.ProxyConf) (*certReloader, error) {
+	r := &certReloader{b: b, proxy: proxy, name: fmt.Sprintf("%s:%d", proxy.Host, proxy.Port)}
+	r.stamps = r.fileStamps()
+	config, err := b.buildTLSConfig(proxy)
+	if err != nil {
+		return nil, err
+	}
+	r.config.Store(config)
+	r.logExpiry()
+	r.checkExpiry(time.Now())
+	return r, nil
+}
+
+func (r *certReloader) current() *tls.Config {
+	if r == nil {
+		return nil
+	}
+	return r.config.Load()
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	if r.proxy.TLS != conf.TLSAuto {
+		files = append(files, r.proxy.Certificate, r.proxy.CertificateKey)
+	}
+	if r.proxy.ClientCA != "" {
+		files = append(files, r.proxy.ClientCA)
+	}
+	return files
+}
+
+// fileStamps follows symlinks, so the atomic directory swaps done by Kubernetes secret mounts are
+// seen as changes too.
+func (r *certReloader) fileStamps() string {
+	var stamps strings.Builder
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			fmt.Fprintf(&stamps, "%s:missing;", file)
+			continue
+		}
+		fmt.Fprintf(&stamps, "%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return stamps.String()
+}
+
+// reload rebuilds the config if any watched file changed since the last attempt and reports
+// whether a new config was put in service.
+func (r *certReloader) reload() bool {
+	stamps := r.fileStamps()
+	if stamps == r.stamps {
+		return false
+	}
+	r.stamps = stamps
+	config, err := r.b.buildTLSConfig(r.proxy)
+	if err != nil {
+		r.b.logger.Error(fmt.Sprintf("Rejected new TLS files for %s, keeping the previous certificate: %v", r.name, err))
+		return false
+	}
+	r.config.Store(config)
+	r.lastWarning = time.Time{}
+	r.b.logger.Info(fmt.Sprintf("Reloaded TLS certificate for %s", r.name))
+	r.logExpiry()
+	r.checkExpiry(time.Now())
+	return true
+}
+
+func (r *certReloader) leaf() *x509.Certificate {
+	config := r.current()
+	if len(config.Certificates) == 0 {
+		return nil
+	}
+	return config.Certificates[0].Leaf
+}
+
+func (r *certReloader) logExpiry() {
+	if leaf := r.leaf(); leaf != nil {
+		r.b.logger.Info(fmt.Sprintf("TLS certificate for %s expires at %s", r.name, leaf.NotAfter.Format(time.RFC3339)))
+	}
+}
+
+// checkExpiry warns at most once a day once the certificate is within certExpiryWarning of
+// expiring.
+func (r *certReloader) checkExpiry(now time.Time) {
+	leaf := r.leaf()
+	if leaf == nil {
+		return
+	}
+	if remaining := leaf.NotAfter.Sub(now); remaining < certExpiryWarning && now.Sub(r.lastWarning) >= certExpiryWarningInterval {
+		r.b.logger.Warn(fmt.Sprintf("TLS certificate for %s expires in %s", r.name, remaining.Round(time.Minute)))
+		r.lastWarning = now
+	}
+}
+
+func (r *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if !r.reload() {
+			r.checkExpiry(now)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"load-balancer/conf"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloader_SwapsAndRejects(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certPath, keyPath := ca.issue(t, dir, "example.com", time.Now().Add(30*24*time.Hour))
+
+	b := newTestBalancer(t)
+	reloader, err := b.newCertReloader(conf.ProxyConf{Host: "example.com", TLS: conf.TLSOn, Certificate: certPath, CertificateKey: keyPath})
+	if err != nil {
+		t.Fatalf("failed to create reloader: %v", err)
+	}
+	original := reloader.leaf()
+	if reloader.reload() {
+		t.Error("expected no reload while files are unchanged")
+	}
+
+	replace := func(src, dst string, mtime time.Time) {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", src, err)
+		}
+		if err := os.WriteFile(dst, data, 0600); err != nil {
+			t.Fatalf("failed to write %s: %v", dst, err)
+		}
+		os.Chtimes(dst, mtime, mtime)
+	}
+	rotated := t.TempDir()
+	newCert, newKey := ca.issue(t, rotated, "example.com", time.Now().Add(24*time.Hour))
+	replace(newCert, certPath, time.Now().Add(time.Minute))
+	replace(newKey, keyPath, time.Now().Add(time.Minute))
+	if !reloader.reload() {
+		t.Fatal("expected rotated files to be reloaded")
+	}
+	renewed := reloader.leaf()
+	if renewed.SerialNumber.Cmp(original.SerialNumber) == 0 {
+		t.Error("expected the rotated certificate to be served")
+	}
+	if reloader.lastWarning.IsZero() {
+		t.Error("expected a warning for a certificate expiring within a day")
+	}
+
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyPath, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+	if reloader.reload() {
+		t.Error("expected an invalid key to be rejected")
+	}
+	if reloader.leaf().SerialNumber.Cmp(renewed.SerialNumber) != 0 {
+		t.Error("expected the previous certificate to stay in service")
+	}
+
+	if _, err := b.newCertReloader(conf.ProxyConf{TLS: conf.TLSOn, Certificate: filepath.Join(dir, "missing"), CertificateKey: keyPath}); err == nil {
+		t.Error("expected an error for a missing certificate at startup")
+	}
+}
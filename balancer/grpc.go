@@ -0,0 +1,43 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// grpcUnavailable is the gRPC status code UNAVAILABLE, which clients treat as retryable.
+const grpcUnavailable = "14"
+
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatus reads grpc-status from the trailers, or from the headers of a trailers-only response.
+func grpcStatus(h http.Header) string {
+	if status := h.Get(http.TrailerPrefix + "Grpc-Status"); status != "" {
+		return status
+	}
+	return h.Get("Grpc-Status")
+}
+
+func (b *Balancer) recordGRPCStatus(host, location, method string, h http.Header) {
+	status := grpcStatus(h)
+	if status == "" {
+		status = "unknown"
+	}
+	b.metrics.Counter("balancer_grpc_responses_total", "location", host+location, "code", status).Inc()
+	if status != "0" {
+		b.logger.Warn(fmt.Sprintf("[%s] gRPC %s finished with status %s %s", host, method, status, h.Get("Grpc-Message")))
+	}
+}
+
+// grpcErrorHandler reports backend failures as a gRPC status, since gRPC clients expect HTTP 200
+// with grpc-status rather than a 502 page.
+func (b *Balancer) grpcErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	b.logger.Error(fmt.Sprintf("gRPC backend error for %s: %v", r.URL.Path, err))
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", grpcUnavailable)
+	w.Header().Set("Grpc-Message", "backend unavailable")
+	w.WriteHeader(http.StatusOK)
+}
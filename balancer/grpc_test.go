@@ -0,0 +1,106 @@
+package balancer
+
+import (
+	"bytes"
+	"io"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func newH2CServer(handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server.Config.Protocols = &protocols
+	server.Start()
+	return server
+}
+
+func TestGRPC_ProxiesOverH2CWithTrailers(t *testing.T) {
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2 to the backend, got %s", r.Proto)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		switch r.URL.Path {
+		case "/grpc.health.v1.Health/Check":
+			w.Write([]byte{0, 0, 0, 0, 2, 0x08, 0x01})
+			w.Header().Set("Grpc-Status", "0")
+		case "/echo.Echo/Fail":
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "not found")
+		default:
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+			w.Header().Set("Grpc-Status", "0")
+		}
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	loc := conf.LocationConf{
+		Path:           "/",
+		Algorithm:      string(algs.RoundRobin),
+		Protocol:       conf.ProtocolGRPC,
+		HealthCheck:    conf.HealthCheckGRPC,
+		BackendServers: []conf.BackendServer{{Host: u.Hostname(), Port: port}},
+	}
+	transport, err := newUpstreamTransport(&loc)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	alg, err := algs.NewAlgorithm(&loc, transport)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+
+	b := newTestBalancer(t)
+	handler := &routeHandler{Path: "/", Alg: alg, Transport: transport, Admission: newAdmission(&loc, alg)}
+	front := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+	}))
+	defer front.Close()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	call := func(method string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, front.URL+method, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	message := []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}
+	resp, body := call("/echo.Echo/Say", message)
+	if resp.ProtoMajor != 2 || !bytes.Equal(body, message) || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("expected an h2c echo with grpc-status 0, got %s %x trailer %v", resp.Proto, body, resp.Trailer)
+	}
+	resp, _ = call("/echo.Echo/Fail", nil)
+	if resp.Trailer.Get("Grpc-Status") != "5" || resp.Trailer.Get("Grpc-Message") != "not found" {
+		t.Errorf("expected trailers to be preserved, got %v", resp.Trailer)
+	}
+	if n := b.metrics.Counter("balancer_grpc_responses_total", "location", "example.com/", "code", "5").Value(); n != 1 {
+		t.Errorf("expected one NOT_FOUND response counted, got %v", n)
+	}
+
+	t.Setenv("RUN_TYPE", "")
+	servers, _ := alg.AllServers()
+	if err := algs.Ping(servers[0]); err != nil {
+		t.Errorf("expected grpc health check to pass: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hostMatcher resolves a request host to a virtual host with nginx server_name precedence:
+//  1. the exact name
+//  2. the longest wildcard starting with an asterisk, e.g. *.example.com
+//  3. the longest wildcard ending with an asterisk, e.g. api.*
+//  4. the first regex name (~pattern) in config order
+//  5. the port's default server
+//
+// A name starting with a dot, e.g. .example.com, matches both example.com and *.example.com.
+type hostMatcher struct {
+	declared    map[string]*virtualHost
+	exact       map[string]*virtualHost
+	leading     []wildcardHost
+	trailing    []wildcardHost
+	regexes     []regexHost
+	defaultHost *virtualHost
+	fallbackTLS *certReloader
+}
+
+type virtualHost struct {
+	name   string
+	router *router
+	tls    *certReloader
+	pool   *streamPool
+}
+
+type wildcardHost struct {
+	affix string
+	host  *virtualHost
+}
+
+type regexHost struct {
+	re   *regexp.Regexp
+	host *virtualHost
+}
+
+func newHostMatcher() *hostMatcher {
+	return &hostMatcher{
+		declared: make(map[string]*virtualHost),
+		exact:    make(map[string]*virtualHost),
+	}
+}
+
+// add returns the virtual host for name, creating it on first use so that several proxy
+// entries with the same name share one router.
+func (m *hostMatcher) add(name string, isDefault bool) (*virtualHost, error) {
+	name = strings.TrimSpace(name)
+	if !strings.HasPrefix(name, "~") {
+		name = strings.ToLower(name)
+	}
+	host, exists := m.declared[name]
+	if !exists {
+		var err error
+		if host, err = m.declare(name); err != nil {
+			return nil, err
+		}
+		m.declared[name] = host
+	}
+	if isDefault {
+		if m.defaultHost != nil && m.defaultHost != host {
+			return nil, fmt.Errorf("host %q and %q are both marked default", m.defaultHost.name, name)
+		}
+		m.defaultHost = host
+	}
+	return host, nil
+}
+
+func (m *hostMatcher) declare(name string) (*virtualHost, error) {
+	host := &virtualHost{name: name, router: newRouter()}
+	switch {
+	case strings.HasPrefix(name, "~"):
+		re, err := regexp.Compile(name[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid host regex %q: %w", name, err)
+		}
+		m.regexes = append(m.regexes, regexHost{re: re, host: host})
+	case strings.HasPrefix(name, "*."):
+		m.leading = append(m.leading, wildcardHost{affix: name[1:], host: host})
+	case strings.HasPrefix(name, "."):
+		m.exact[name[1:]] = host
+		m.leading = append(m.leading, wildcardHost{affix: name, host: host})
+	case strings.HasSuffix(name, ".*"):
+		m.trailing = append(m.trailing, wildcardHost{affix: name[:len(name)-1], host: host})
+	case strings.Contains(name, "*"):
+		return nil, errors.New("wildcard host " + name + " must start with *. or end with .*")
+	default:
+		m.exact[name] = host
+	}
+	sortByAffixLength(m.leading)
+	sortByAffixLength(m.trailing)
+	return host, nil
+}
+
+func sortByAffixLength(hosts []wildcardHost) {
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return len(hosts[i].affix) > len(hosts[j].affix)
+	})
+}
+
+func (m *hostMatcher) match(host string) *virtualHost {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if h, exists := m.exact[host]; exists {
+		return h
+	}
+	for _, w := range m.leading {
+		if strings.HasSuffix(host, w.affix) {
+			return w.host
+		}
+	}
+	for _, w := range m.trailing {
+		if strings.HasPrefix(host, w.affix) {
+			return w.host
+		}
+	}
+	for _, r := range m.regexes {
+		if r.re.MatchString(host) {
+			return r.host
+		}
+	}
+	return m.defaultHost
+}
@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"testing"
+)
+
+func TestHostMatcher_Precedence(t *testing.T) {
+	m := newHostMatcher()
+	for _, name := range []string{
+		"example.com",
+		"*.example.com",
+		"*.api.example.com",
+		"api.*",
+		"api.internal.*",
+		`~^(?P<tenant>\w+)\.tenants\.net$`,
+		".shop.io",
+	} {
+		if _, err := m.add(name, false); err != nil {
+			t.Fatalf("failed to add %q: %v", name, err)
+		}
+	}
+	fallback, err := m.add("fallback.local", true)
+	if err != nil {
+		t.Fatalf("failed to add default host: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com.", "example.com"},
+		{"www.example.com", "*.example.com"},
+		{"v1.api.example.com", "*.api.example.com"},
+		{"api.example.com", "*.example.com"},
+		{"api.example.org", "api.*"},
+		{"api.internal.corp", "api.internal.*"},
+		{"acme.tenants.net", `~^(?P<tenant>\w+)\.tenants\.net$`},
+		{"shop.io", ".shop.io"},
+		{"eu.shop.io", ".shop.io"},
+		{"unknown.org", "fallback.local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			vhost := m.match(tt.host)
+			if vhost == nil {
+				t.Fatalf("no virtual host for %s", tt.host)
+			}
+			if vhost.name != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, vhost.name)
+			}
+		})
+	}
+
+	if again, _ := m.add("fallback.local", true); again != fallback {
+		t.Error("adding the same name twice should return the same virtual host")
+	}
+	if _, err := m.add("example.com", true); err == nil {
+		t.Error("expected error for a second default host on the same port")
+	}
+}
+
+func TestHostMatcher_NoDefault(t *testing.T) {
+	m := newHostMatcher()
+	m.add("example.com", false)
+	if vhost := m.match("other.com"); vhost != nil {
+		t.Errorf("expected no match without a default host, got %q", vhost.name)
+	}
+	if _, err := m.add("ex*ample.com", false); err == nil {
+		t.Error("expected error for a wildcard in the middle of a name")
+	}
+}
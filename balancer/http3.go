@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// portHTTP3 reports whether a TLS port should also serve HTTP/3 on the same UDP port number.
+func (b *Balancer) portHTTP3(port int, tlsConfig *tls.Config) (bool, error) {
+	enabled := false
+	for _, proxy := range b.getProxiesByPort(port) {
+		enabled = enabled || proxy.HTTP3
+	}
+	if !enabled {
+		return false, nil
+	}
+	if tlsConfig == nil {
+		return false, fmt.Errorf("http3 on port %d requires tls", port)
+	}
+	if _, exists := b.udpProxies[port]; exists {
+		return false, fmt.Errorf("http3 on port %d conflicts with a udp proxy", port)
+	}
+	return true, nil
+}
+
+// newHTTP3Server serves the port's routes over QUIC. It shares the TCP listener's TLS config, so
+// SNI host selection and certificate reloads apply to HTTP/3 handshakes too.
+func (b *Balancer) newHTTP3Server(port int, tlsConfig *tls.Config, handler http.Handler) *http3.Server {
+	server := &http3.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	b.trackServer(server)
+	return server
+}
+
+func (b *Balancer) serveHTTP3(server *http3.Server, port int) {
+	conn, err := net.ListenPacket("udp", server.Addr)
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("Failed to listen for HTTP/3 on udp port %d: %v", port, err))
+		return
+	}
+	b.logger.Info(fmt.Sprintf("Listening with HTTP/3 on udp %s", server.Addr))
+	if err := server.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		b.logger.Error(fmt.Sprintf("HTTP/3 server error on port %d: %v", port, err))
+	}
+}
+
+// withAltSvc advertises the HTTP/3 endpoint on responses sent over TCP.
+func withAltSvc(server *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			server.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"load-balancer/conf"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHTTP3_ServesRoutesAndAdvertisesAltSvc(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certPath, keyPath := ca.issue(t, dir, "a.example.com", time.Now().Add(time.Hour))
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from backend")
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	b := newTestBalancer(t)
+	b.conf.Proxies = []conf.ProxyConf{{
+		Port: 8443, Host: "a.example.com", TLS: conf.TLSOn, HTTP3: true, Certificate: certPath, CertificateKey: keyPath,
+		Locations: []conf.LocationConf{{Path: "/", Algorithm: "RoundRobin", BackendServers: []conf.BackendServer{{Host: u.Hostname(), Port: port}}}},
+	}}
+	if err := b.registerProxy(b.conf.Proxies[0]); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	tlsConfig, err := b.portTLSConfig(8443, b.hostRouter[8443])
+	if err != nil {
+		t.Fatalf("portTLSConfig failed: %v", err)
+	}
+	if enabled, err := b.portHTTP3(8443, tlsConfig); err != nil || !enabled {
+		t.Fatalf("expected http3 on port 8443, got %v, error: %v", enabled, err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.routeRequest(w, r, 8443, b.hostRouter[8443])
+	})
+	h3 := b.newHTTP3Server(8443, tlsConfig, handler)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go h3.Serve(conn)
+	defer h3.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "a.example.com"}}
+	defer transport.Close()
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/", conn.LocalAddr()), nil)
+	req.Host = "a.example.com"
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("HTTP/3 request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ProtoMajor != 3 || string(body) != "hello from backend" {
+		t.Errorf("expected the route to answer over HTTP/3, got %s %q", resp.Proto, body)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	withAltSvc(h3, http.NotFoundHandler()).ServeHTTP(w, r)
+	udpPort := conn.LocalAddr().(*net.UDPAddr).Port
+	if altSvc := w.Header().Get("Alt-Svc"); !strings.Contains(altSvc, fmt.Sprintf(`h3=":%d"`, udpPort)) {
+		t.Errorf("expected Alt-Svc to advertise h3 on %d, got %q", udpPort, altSvc)
+	}
+
+	b.conf.Proxies = append(b.conf.Proxies, conf.ProxyConf{Port: 8080, Host: "plain.example.com", HTTP3: true})
+	if _, err := b.portHTTP3(8080, nil); err == nil {
+		t.Error("expected http3 without tls to be rejected")
+	}
+}
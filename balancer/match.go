@@ -0,0 +1,117 @@
+package balancer
+
+import (
+	"fmt"
+	"load-balancer/conf"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// requestMatcher holds the conditions of a location's match block; all of them must hold.
+type requestMatcher struct {
+	methods map[string]bool
+	headers []valueMatcher
+	query   []valueMatcher
+	cookies []valueMatcher
+}
+
+// valueMatcher checks a named value for equality, a regex, or bare presence when neither is set.
+type valueMatcher struct {
+	name   string
+	equals string
+	re     *regexp.Regexp
+}
+
+func newRequestMatcher(m *conf.MatchConf) (*requestMatcher, error) {
+	if m == nil {
+		return nil, nil
+	}
+	matcher := &requestMatcher{}
+	if len(m.Methods) > 0 {
+		matcher.methods = make(map[string]bool, len(m.Methods))
+		for _, method := range m.Methods {
+			matcher.methods[strings.ToUpper(method)] = true
+		}
+	}
+	var err error
+	if matcher.headers, err = newValueMatchers("header", m.Headers); err != nil {
+		return nil, err
+	}
+	if matcher.query, err = newValueMatchers("query", m.Query); err != nil {
+		return nil, err
+	}
+	if matcher.cookies, err = newValueMatchers("cookie", m.Cookies); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+func newValueMatchers(kind string, confs []conf.ValueMatchConf) ([]valueMatcher, error) {
+	matchers := make([]valueMatcher, 0, len(confs))
+	for _, c := range confs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("%s match requires a name", kind)
+		}
+		if c.Equals != "" && c.Regex != "" {
+			return nil, fmt.Errorf("%s match %s sets both equals and regex", kind, c.Name)
+		}
+		v := valueMatcher{name: c.Name, equals: c.Equals}
+		if c.Regex != "" {
+			re, err := regexp.Compile(c.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regex for %s: %w", kind, c.Name, err)
+			}
+			v.re = re
+		}
+		matchers = append(matchers, v)
+	}
+	return matchers, nil
+}
+
+func (m *requestMatcher) matches(r *http.Request) bool {
+	if m == nil {
+		return true
+	}
+	if m.methods != nil && !m.methods[r.Method] {
+		return false
+	}
+	for _, h := range m.headers {
+		if !h.matches(r.Header.Values(h.name)) {
+			return false
+		}
+	}
+	if len(m.query) > 0 {
+		query := r.URL.Query()
+		for _, q := range m.query {
+			if !q.matches(query[q.name]) {
+				return false
+			}
+		}
+	}
+	for _, c := range m.cookies {
+		var values []string
+		if cookie, err := r.Cookie(c.name); err == nil {
+			values = []string{cookie.Value}
+		}
+		if !c.matches(values) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v valueMatcher) matches(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if v.equals == "" && v.re == nil {
+		return true
+	}
+	for _, value := range values {
+		if (v.re != nil && v.re.MatchString(value)) || (v.re == nil && value == v.equals) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestMatcher(t *testing.T) {
+	matcher, err := newRequestMatcher(&conf.MatchConf{
+		Methods: []string{"get", "POST"},
+		Headers: []conf.ValueMatchConf{{Name: "X-Api-Version", Equals: "2"}},
+		Query:   []conf.ValueMatchConf{{Name: "debug"}},
+		Cookies: []conf.ValueMatchConf{{Name: "plan", Regex: "^(pro|team)$"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create matcher: %v", err)
+	}
+
+	build := func(method, target string, header http.Header, cookie string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "plan", Value: cookie})
+		}
+		return r
+	}
+	v2 := http.Header{"X-Api-Version": {"2"}}
+	tests := []struct {
+		name string
+		r    *http.Request
+		want bool
+	}{
+		{"all conditions hold", build(http.MethodPost, "/?debug", v2, "pro"), true},
+		{"method not in set", build(http.MethodDelete, "/?debug", v2, "pro"), false},
+		{"header differs", build(http.MethodGet, "/?debug", http.Header{"X-Api-Version": {"1"}}, "pro"), false},
+		{"header missing", build(http.MethodGet, "/?debug", nil, "pro"), false},
+		{"query missing", build(http.MethodGet, "/", v2, "pro"), false},
+		{"cookie regex fails", build(http.MethodGet, "/?debug=1", v2, "free"), false},
+		{"cookie missing", build(http.MethodGet, "/?debug=1", v2, ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matcher.matches(tt.r); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	var none *requestMatcher
+	if !none.matches(httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Error("a location without a match block should always match")
+	}
+	if _, err := newRequestMatcher(&conf.MatchConf{Headers: []conf.ValueMatchConf{{Name: "X", Regex: "("}}}); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
+
+func TestRouter_MatchBlocks(t *testing.T) {
+	v2, _ := newRequestMatcher(&conf.MatchConf{Headers: []conf.ValueMatchConf{{Name: "X-Api-Version", Equals: "2"}}})
+	upload, _ := newRequestMatcher(&conf.MatchConf{Methods: []string{http.MethodPost}})
+	rt := newRouter()
+	rt.add("/", &routeHandler{Path: "root"})
+	rt.add("/api", &routeHandler{Path: "api-v2", Match: v2})
+	rt.add("/api", &routeHandler{Path: "api"})
+	rt.add("/api", &routeHandler{Path: "api-shadowed", Match: v2})
+	rt.add("/upload", &routeHandler{Path: "upload-post", Match: upload})
+	if len(rt.warnings) != 1 {
+		t.Errorf("expected a warning for the shadowed location, got %v", rt.warnings)
+	}
+
+	tests := []struct {
+		method  string
+		path    string
+		version string
+		want    string
+	}{
+		{http.MethodGet, "/api/items", "2", "api-v2"},
+		{http.MethodGet, "/api/items", "1", "api"},
+		{http.MethodPost, "/upload", "", "upload-post"},
+		{http.MethodGet, "/upload", "", "root"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.version != "" {
+			r.Header.Set("X-Api-Version", tt.version)
+		}
+		handler, _ := rt.match(tt.path, r)
+		if handler == nil || handler.Path != tt.want {
+			t.Errorf("%s %s (version %q): expected %s, got %v", tt.method, tt.path, tt.version, tt.want, handler)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const defaultMetricsPath = "/metrics"
+
+func (b *Balancer) serveMetrics() {
+	path := b.conf.Metrics.Path
+	if path == "" {
+		path = defaultMetricsPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, b.metrics.Handler())
+	addr := fmt.Sprintf(":%d", b.conf.Metrics.Port)
+	b.logger.Info(fmt.Sprintf("Serving metrics on %s%s", addr, path))
+	if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		b.logger.Error(fmt.Sprintf("Metrics server error on %s: %v", addr, err))
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// onHeader, when set, is called with the final status before it is written.
+	onHeader func(status int)
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	if r.onHeader != nil && status >= http.StatusOK {
+		r.onHeader(status)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,169 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// hopHeaders only describe the client's connection, which the shadow request does not share.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade"}
+
+const (
+	defaultMirrorBodySize    = 64 << 10
+	defaultMirrorConnections = 100
+	defaultMirrorTimeout     = 10 * time.Second
+	defaultMirrorHeader      = "X-Mirrored-Request"
+	mirrorResultOK           = "ok"
+	mirrorResultError        = "error"
+	mirrorResultDropped      = "dropped"
+	mirrorResultBodyTooLarge = "body_too_large"
+	mirrorResultStreaming    = "streaming"
+)
+
+// mirror sends copies of requests to a shadow pool. The client never waits on it: the copy runs
+// in its own goroutine, with its own deadline, and its response is read and thrown away.
+type mirror struct {
+	percent   float64
+	maxBody   int64
+	header    string
+	timeout   time.Duration
+	transport http.RoundTripper
+	admission *admission
+}
+
+func newMirror(loc *conf.LocationConf, transport http.RoundTripper) (*mirror, error) {
+	c := loc.Mirror
+	if c == nil {
+		return nil, nil
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("mirror percent %v is not between 0 and 100", c.Percent)
+	}
+	if len(c.BackendServers) == 0 {
+		return nil, fmt.Errorf("mirror requires backend_servers")
+	}
+	shadowLoc := *loc
+	shadowLoc.Groups = nil
+	shadowLoc.Mirror = nil
+	shadowLoc.BackendServers = c.BackendServers
+	shadowLoc.MaxPending = 0
+	shadowLoc.MaxConnections = c.MaxConnections
+	if shadowLoc.MaxConnections <= 0 {
+		shadowLoc.MaxConnections = defaultMirrorConnections
+	}
+	if c.Algorithm != "" {
+		shadowLoc.Algorithm = c.Algorithm
+	}
+	alg, err := algs.NewAlgorithm(&shadowLoc, transport)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: %w", err)
+	}
+	m := &mirror{
+		percent:   c.Percent,
+		maxBody:   c.MaxBodySize,
+		header:    c.Header,
+		timeout:   c.Timeout,
+		transport: transport,
+		admission: newAdmission(&shadowLoc, alg),
+	}
+	if m.maxBody <= 0 {
+		m.maxBody = defaultMirrorBodySize
+	}
+	if m.header == "" {
+		m.header = defaultMirrorHeader
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultMirrorTimeout
+	}
+	return m, nil
+}
+
+// mirrorRequest samples out and, if chosen, buffers its body so that the primary and the shadow
+// each get a full copy. out.Body is replaced and must be used for the primary request. Only
+// bodies of a known length are buffered: streams such as gRPC calls or chunked uploads may never
+// end before the primary answers, so they are not mirrored.
+func (b *Balancer) mirrorRequest(m *mirror, location string, out *http.Request) {
+	if m == nil || rand.Float64()*100 >= m.percent {
+		return
+	}
+	var body []byte
+	if out.Body != nil && out.Body != http.NoBody {
+		if isGRPC(out) || out.ContentLength < 0 {
+			b.metrics.Counter("balancer_mirror_requests_total", "location", location, "result", mirrorResultStreaming).Inc()
+			return
+		}
+		if out.ContentLength > m.maxBody {
+			b.metrics.Counter("balancer_mirror_requests_total", "location", location, "result", mirrorResultBodyTooLarge).Inc()
+			return
+		}
+		buffered, err := io.ReadAll(io.LimitReader(out.Body, out.ContentLength))
+		if err != nil {
+			out.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buffered), out.Body), out.Body}
+			return
+		}
+		body = buffered
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	server, err := m.admission.acquireNow("")
+	if err != nil {
+		b.metrics.Counter("balancer_mirror_requests_total", "location", location, "result", mirrorResultDropped).Inc()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(out.Context()), m.timeout)
+	shadow := out.Clone(ctx)
+	shadow.RequestURI = ""
+	shadow.Body = http.NoBody
+	if body != nil {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	for _, name := range hopHeaders {
+		shadow.Header.Del(name)
+	}
+	shadow.Header.Set(m.header, "true")
+	go func() {
+		defer cancel()
+		defer m.admission.release(server)
+		start := time.Now()
+		result := mirrorResultOK
+		if err := m.send(server, shadow); err != nil {
+			result = mirrorResultError
+			b.logger.Warn(fmt.Sprintf("Mirror of %s %s to %s failed: %v", shadow.Method, location, server.GetUrl(), err))
+		}
+		b.metrics.Counter("balancer_mirror_requests_total", "location", location, "result", result).Inc()
+		b.metrics.Counter("balancer_mirror_duration_seconds_sum", "location", location).Add(time.Since(start).Seconds())
+		b.metrics.Counter("balancer_mirror_duration_seconds_count", "location", location).Inc()
+	}()
+}
+
+// send treats 5xx responses as errors so a broken rewrite shows up next to transport
+// failures.
+func (m *mirror) send(server algs.IBackendServer, shadow *http.Request) error {
+	target, err := url.Parse(server.GetUrl())
+	if err != nil {
+		return err
+	}
+	shadow.URL.Scheme, shadow.URL.Host = target.Scheme, target.Host
+	resp, err := m.transport.RoundTrip(shadow)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"io"
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type shadowRequest struct {
+	path, body, marker string
+}
+
+func newShadowBackend(t *testing.T, status int, delay time.Duration) (conf.BackendServer, chan shadowRequest) {
+	t.Helper()
+	seen := make(chan shadowRequest, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		seen <- shadowRequest{path: r.URL.Path, body: string(body), marker: r.Header.Get(defaultMirrorHeader)}
+	}))
+	t.Cleanup(backend.Close)
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return conf.BackendServer{Host: u.Hostname(), Port: port}, seen
+}
+
+func newMirrorHandler(t *testing.T, mirror *conf.MirrorConf) (*Balancer, *routeHandler) {
+	t.Helper()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(primary.Close)
+	u, _ := url.Parse(primary.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	b := newTestBalancer(t)
+	proxy := conf.ProxyConf{Port: 8080, Host: "example.com", Locations: []conf.LocationConf{{
+		Path:           "/",
+		Algorithm:      "RoundRobin",
+		Mirror:         mirror,
+		BackendServers: []conf.BackendServer{{Host: u.Hostname(), Port: port}},
+	}}}
+	if err := b.registerProxy(proxy); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	handler, _ := b.hostRouter[8080].match("example.com").router.match("/", httptest.NewRequest(http.MethodGet, "/", nil))
+	return b, handler
+}
+
+func TestProxyRequest_Mirror(t *testing.T) {
+	shadow, seen := newShadowBackend(t, http.StatusOK, 0)
+	b, handler := newMirrorHandler(t, &conf.MirrorConf{Percent: 100, MaxBodySize: 16, BackendServers: []conf.BackendServer{shadow}})
+	ok := b.metrics.Counter("balancer_mirror_requests_total", "location", "example.com/", "result", mirrorResultOK)
+	tooLarge := b.metrics.Counter("balancer_mirror_requests_total", "location", "example.com/", "result", mirrorResultBodyTooLarge)
+	okBefore, tooLargeBefore := ok.Value(), tooLarge.Value()
+
+	w := httptest.NewRecorder()
+	b.proxyRequest(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("small body")), "example.com", "/orders", handler, nil)
+	if w.Body.String() != "small body" {
+		t.Errorf("expected the primary to receive the full body, got %q", w.Body.String())
+	}
+	select {
+	case got := <-seen:
+		if got.path != "/orders" || got.body != "small body" || got.marker != "true" {
+			t.Errorf("unexpected shadow request %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the request to be mirrored")
+	}
+	waitFor(t, func() bool { return ok.Value() == okBefore+1 })
+
+	large := strings.Repeat("x", 100)
+	w = httptest.NewRecorder()
+	b.proxyRequest(w, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(large)), "example.com", "/upload", handler, nil)
+	if w.Body.String() != large {
+		t.Errorf("expected the primary to receive the full %d byte body, got %d bytes", len(large), w.Body.Len())
+	}
+	if tooLarge.Value() != tooLargeBefore+1 {
+		t.Error("expected a body over max_body_size to skip the mirror")
+	}
+	select {
+	case got := <-seen:
+		t.Errorf("expected no shadow request for a large body, got %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProxyRequest_MirrorSkipsStreamingBodies(t *testing.T) {
+	shadow, seen := newShadowBackend(t, http.StatusOK, 0)
+	b, handler := newMirrorHandler(t, &conf.MirrorConf{Percent: 100, BackendServers: []conf.BackendServer{shadow}})
+	streaming := b.metrics.Counter("balancer_mirror_requests_total", "location", "example.com/", "result", mirrorResultStreaming)
+	before := streaming.Value()
+
+	// the client is still sending, so buffering for the mirror would stall the primary request
+	body, writer := io.Pipe()
+	defer writer.Close()
+	go writer.Write([]byte("first chunk"))
+	chunked := httptest.NewRequest(http.MethodPost, "/upload", body)
+	chunked.ContentLength = -1
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.proxyRequest(httptest.NewRecorder(), chunked, "example.com", "/upload", handler, nil)
+	}()
+	waitFor(t, func() bool { return streaming.Value() == before+1 })
+	writer.Close()
+	<-done
+
+	grpc := httptest.NewRequest(http.MethodPost, "/echo.Echo/Say", strings.NewReader("message"))
+	grpc.Header.Set("Content-Type", "application/grpc")
+	b.proxyRequest(httptest.NewRecorder(), grpc, "example.com", "/echo.Echo/Say", handler, nil)
+	if streaming.Value() != before+2 {
+		t.Error("expected gRPC requests not to be mirrored")
+	}
+	select {
+	case got := <-seen:
+		t.Errorf("expected no shadow request for streaming bodies, got %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProxyRequest_MirrorFailureDoesNotAffectClient(t *testing.T) {
+	shadow, seen := newShadowBackend(t, http.StatusInternalServerError, 300*time.Millisecond)
+	b, handler := newMirrorHandler(t, &conf.MirrorConf{Percent: 100, BackendServers: []conf.BackendServer{shadow}})
+	failed := b.metrics.Counter("balancer_mirror_requests_total", "location", "example.com/", "result", mirrorResultError)
+	before := failed.Value()
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	b.proxyRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "example.com", "/", handler, nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the primary's 200, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("expected the client not to wait for the shadow, took %v", elapsed)
+	}
+	<-seen
+	waitFor(t, func() bool { return failed.Value() == before+1 })
+}
+
+func TestNewMirror_Validates(t *testing.T) {
+	if _, err := newMirror(&conf.LocationConf{Algorithm: "RoundRobin", Mirror: &conf.MirrorConf{Percent: 150, BackendServers: []conf.BackendServer{{Host: "localhost", Port: 8001}}}}, nil); err == nil {
+		t.Error("expected error for percent over 100")
+	}
+	if _, err := newMirror(&conf.LocationConf{Algorithm: "RoundRobin", Mirror: &conf.MirrorConf{Percent: 10}}, nil); err == nil {
+		t.Error("expected error without shadow backends")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,282 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyV1MaxLength     = 107
+	proxyHeaderTimeout   = 5 * time.Second
+	proxyProtocolV1      = "v1"
+	proxyProtocolV2      = "v2"
+	proxyV2CommandLocal  = 0x0
+	proxyV2CommandProxy  = 0x1
+	proxyV2FamilyTCP4    = 0x11
+	proxyV2FamilyTCP6    = 0x21
+	proxyV2AddrLenTCP4   = 12
+	proxyV2AddrLenTCP6   = 36
+	proxyV2HeaderLength  = 16
+	proxyV2VersionNibble = 0x2
+)
+
+type clientAddrKey struct{}
+type localAddrKey struct{}
+
+type proxyProtoListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func newProxyProtoListener(ln net.Listener, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = proxyHeaderTimeout
+	}
+	return &proxyProtoListener{Listener: ln, timeout: timeout}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyProtoConn parses the PROXY header lazily so a slow client cannot block Accept.
+type proxyProtoConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	timeout    time.Duration
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		src, dst, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = fmt.Errorf("invalid proxy protocol header from %s: %w", c.Conn.RemoteAddr(), err)
+			c.Conn.Close()
+			return
+		}
+		c.remoteAddr = src
+		c.localAddr = dst
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtoConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	c.init()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader returns nil addresses for LOCAL/UNKNOWN headers, meaning the socket addresses apply.
+func readProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, nil, errors.New("missing proxy protocol header")
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("v1 header is not terminated by CRLF")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed v1 header %q", string(line))
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid address %q", ip)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: p}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != proxyV2VersionNibble {
+		return nil, nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+	command := header[12] & 0x0F
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	if command == proxyV2CommandLocal {
+		return nil, nil, nil
+	}
+	if command != proxyV2CommandProxy {
+		return nil, nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+	switch family {
+	case proxyV2FamilyTCP4:
+		if len(payload) < proxyV2AddrLenTCP4 {
+			return nil, nil, errors.New("short v2 TCP4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}, nil
+	case proxyV2FamilyTCP6:
+		if len(payload) < proxyV2AddrLenTCP6 {
+			return nil, nil, errors.New("short v2 TCP6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+func buildProxyHeader(version string, src, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOk := src.(*net.TCPAddr)
+	dstTCP, dstOk := dst.(*net.TCPAddr)
+	switch version {
+	case proxyProtocolV1:
+		if !srcOk || !dstOk {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP4"
+		if srcTCP.IP.To4() == nil || dstTCP.IP.To4() == nil {
+			family = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcTCP.IP, dstTCP.IP, srcTCP.Port, dstTCP.Port)), nil
+	case proxyProtocolV2:
+		header := append([]byte{}, proxyV2Signature...)
+		if !srcOk || !dstOk {
+			return append(header, proxyV2VersionNibble<<4|proxyV2CommandLocal, 0x00, 0x00, 0x00), nil
+		}
+		var payload []byte
+		family := byte(proxyV2FamilyTCP4)
+		if src4, dst4 := srcTCP.IP.To4(), dstTCP.IP.To4(); src4 != nil && dst4 != nil {
+			payload = append(payload, src4...)
+			payload = append(payload, dst4...)
+		} else {
+			family = proxyV2FamilyTCP6
+			payload = append(payload, srcTCP.IP.To16()...)
+			payload = append(payload, dstTCP.IP.To16()...)
+		}
+		payload = binary.BigEndian.AppendUint16(payload, uint16(srcTCP.Port))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(dstTCP.Port))
+		header = append(header, proxyV2VersionNibble<<4|proxyV2CommandProxy, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+		return append(header, payload...), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy protocol version %q", version)
+	}
+}
+
+// proxyProtoDialer writes a PROXY header describing the original client on every new upstream connection.
+func proxyProtoDialer(version string, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		src, _ := ctx.Value(clientAddrKey{}).(net.Addr)
+		dst, _ := ctx.Value(localAddrKey{}).(net.Addr)
+		header, err := buildProxyHeader(version, src, dst)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to write proxy protocol header: %w", err)
+		}
+		return conn, nil
+	}
+}
+
+func withClientAddrs(ctx context.Context, remoteAddr string) context.Context {
+	if local, ok := ctx.Value(http.LocalAddrContextKey).(net.Addr); ok {
+		ctx = context.WithValue(ctx, localAddrKey{}, local)
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", remoteAddr); err == nil {
+		ctx = context.WithValue(ctx, clientAddrKey{}, net.Addr(addr))
+	}
+	return ctx
+}
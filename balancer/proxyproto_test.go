@@ -0,0 +1,155 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 8443}
+
+	tests := []struct {
+		name    string
+		version string
+		src     net.Addr
+		dst     net.Addr
+	}{
+		{"v1 tcp4", proxyProtocolV1, src, dst},
+		{"v1 tcp6", proxyProtocolV1, src6, dst6},
+		{"v2 tcp4", proxyProtocolV2, src, dst},
+		{"v2 tcp6", proxyProtocolV2, src6, dst6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := buildProxyHeader(tt.version, tt.src, tt.dst)
+			if err != nil {
+				t.Fatalf("buildProxyHeader failed: %v", err)
+			}
+			r := bufio.NewReader(bytes.NewReader(append(header, []byte("GET / HTTP/1.1\r\n")...)))
+			gotSrc, gotDst, err := readProxyHeader(r)
+			if err != nil {
+				t.Fatalf("readProxyHeader failed: %v", err)
+			}
+			if gotSrc.String() != tt.src.String() || gotDst.String() != tt.dst.String() {
+				t.Errorf("expected %s -> %s, got %s -> %s", tt.src, tt.dst, gotSrc, gotDst)
+			}
+			rest, _ := io.ReadAll(r)
+			if string(rest) != "GET / HTTP/1.1\r\n" {
+				t.Errorf("header parsing consumed payload, remaining %q", rest)
+			}
+		})
+	}
+
+	if _, _, err := readProxyHeader(bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")))); err == nil {
+		t.Error("expected error for connection without proxy header")
+	}
+	if src, _, err := readProxyHeader(bufio.NewReader(bytes.NewReader([]byte("PROXY UNKNOWN\r\n")))); err != nil || src != nil {
+		t.Errorf("expected UNKNOWN header to fall back to socket address, got %v, %v", src, err)
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	})}
+	go server.Serve(newProxyProtoListener(ln, proxyHeaderTimeout))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "PROXY TCP4 198.51.100.9 10.0.0.1 40123 80\r\nGET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "198.51.100.9:40123" {
+		t.Errorf("expected RemoteAddr from proxy header, got %q", body)
+	}
+}
+
+func TestProxyProtoConn_CloseWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	pln := newProxyProtoListener(ln, proxyHeaderTimeout)
+	defer pln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := pln.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer conn.Close()
+
+	cw, ok := conn.(closeWriter)
+	if !ok {
+		t.Fatalf("expected %T to support CloseWrite", conn)
+	}
+	if err := cw.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+	if n, err := client.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF after CloseWrite, got %d, %v", n, err)
+	}
+	fmt.Fprint(client, "PROXY TCP4 198.51.100.9 10.0.0.1 40123 80\r\nping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("expected the read side to stay open, got %q, %v", buf, err)
+	}
+}
+
+func TestProxyProtoDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		src, _, err := readProxyHeader(bufio.NewReader(conn))
+		if err != nil {
+			t.Errorf("backend failed to read proxy header: %v", err)
+		}
+		received <- src
+	}()
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.44"), Port: 5555}
+	ctx := context.WithValue(context.Background(), clientAddrKey{}, net.Addr(client))
+	ctx = context.WithValue(ctx, localAddrKey{}, net.Addr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}))
+	conn, err := proxyProtoDialer(proxyProtocolV2, &net.Dialer{})(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	if got := <-received; got == nil || got.String() != client.String() {
+		t.Errorf("expected backend to see client %s, got %v", client, got)
+	}
+}
@@ -0,0 +1,163 @@
+package balancer
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"sync"
+	"time"
+)
+
+const defaultQueueTimeout = 10 * time.Second
+
+var (
+	errQueueFull    = errors.New("request queue is full")
+	errQueueTimeout = errors.New("timed out waiting in request queue")
+)
+
+// admission bounds in-flight requests of a location and parks the excess in a FIFO queue
+// until a backend slot is released.
+type admission struct {
+	alg        algs.IAlgorithm
+	attempts   int
+	maxActive  int
+	maxPending int
+	timeout    time.Duration
+	mu         sync.Mutex
+	active     int
+	waiters    *list.List
+}
+
+func newAdmission(loc *conf.LocationConf, alg algs.IAlgorithm) *admission {
+	maxPending := loc.MaxPending
+	for _, server := range loc.BackendServers {
+		maxPending += server.MaxPending
+	}
+	timeout := loc.QueueTimeout
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	// a server can fill up between NextServer and Acquire, so each one gets a chance
+	servers, _ := alg.AllServers()
+	return &admission{
+		alg:        alg,
+		attempts:   max(len(servers), 1),
+		maxActive:  loc.MaxConnections,
+		maxPending: maxPending,
+		timeout:    timeout,
+		waiters:    list.New(),
+	}
+}
+
+// acquire returns a backend with a reserved slot; the caller must pass it to release when done.
+// A healthy pinned backend is always used, waiting in the queue while it is at capacity.
+func (a *admission) acquire(ctx context.Context, key string, pinned algs.IBackendServer) (algs.IBackendServer, error) {
+	deadline := time.NewTimer(a.timeout)
+	defer deadline.Stop()
+	woken := false
+	for {
+		a.mu.Lock()
+		if woken || a.waiters.Len() == 0 {
+			server, err := a.tryAcquire(key, pinned)
+			if err == nil {
+				a.mu.Unlock()
+				return server, nil
+			}
+			if !errors.Is(err, algs.ErrNoCapacity) {
+				a.mu.Unlock()
+				return nil, err
+			}
+		}
+		if !woken && a.waiters.Len() >= a.maxPending {
+			a.mu.Unlock()
+			return nil, errQueueFull
+		}
+		ready := make(chan struct{})
+		var elem *list.Element
+		if woken {
+			elem = a.waiters.PushFront(ready)
+		} else {
+			elem = a.waiters.PushBack(ready)
+		}
+		a.mu.Unlock()
+
+		select {
+		case <-ready:
+			woken = true
+		case <-deadline.C:
+			a.abandon(elem, ready)
+			return nil, errQueueTimeout
+		case <-ctx.Done():
+			a.abandon(elem, ready)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquireNow reserves a slot without queueing, for callers that cannot wait such as datagrams.
+func (a *admission) acquireNow(key string) (algs.IBackendServer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.tryAcquire(key, nil)
+}
+
+// tryAcquire routes by key when the algorithm supports it, e.g. the Hash algorithm.
+func (a *admission) tryAcquire(key string, pinned algs.IBackendServer) (algs.IBackendServer, error) {
+	if a.maxActive > 0 && a.active >= a.maxActive {
+		return nil, algs.ErrNoCapacity
+	}
+	if pinned != nil {
+		if !pinned.Acquire() {
+			return nil, algs.ErrNoCapacity
+		}
+		a.active++
+		return pinned, nil
+	}
+	for range a.attempts {
+		server, err := a.next(key)
+		if err != nil {
+			return nil, err
+		}
+		if server.Acquire() {
+			a.active++
+			return server, nil
+		}
+	}
+	return nil, algs.ErrNoCapacity
+}
+
+func (a *admission) next(key string) (algs.IBackendServer, error) {
+	if keyed, ok := a.alg.(algs.IKeyedAlgorithm); ok && key != "" {
+		return keyed.NextServerForKey(key)
+	}
+	return a.alg.NextServer()
+}
+
+// abandon removes a waiter that gave up, passing on a wake-up it may have received meanwhile.
+func (a *admission) abandon(elem *list.Element, ready chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-ready:
+		a.wakeNext()
+	default:
+		a.waiters.Remove(elem)
+	}
+}
+
+func (a *admission) release(server algs.IBackendServer) {
+	server.Release()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.active--
+	a.wakeNext()
+}
+
+func (a *admission) wakeNext() {
+	if front := a.waiters.Front(); front != nil {
+		a.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+	}
+}
@@ -0,0 +1,105 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestAdmission(t *testing.T, loc *conf.LocationConf) *admission {
+	os.Setenv("RUN_TYPE", "test")
+	alg, err := algs.NewAlgorithm(loc, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	return newAdmission(loc, alg)
+}
+
+func TestAdmission_QueuesUntilRelease(t *testing.T) {
+	a := newTestAdmission(t, &conf.LocationConf{
+		Algorithm:    "RoundRobin",
+		MaxPending:   2,
+		QueueTimeout: time.Second,
+		BackendServers: []conf.BackendServer{
+			{Host: "localhost", Port: 8001, MaxConnections: 1},
+		},
+	})
+	first, err := a.acquire(context.Background(), "", nil)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func(i int) {
+			server, err := a.acquire(context.Background(), "", nil)
+			if err != nil {
+				t.Errorf("queued acquire %d failed: %v", i, err)
+				return
+			}
+			order <- i
+			a.release(server)
+		}(i)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, err := a.acquire(context.Background(), "", nil); !errors.Is(err, errQueueFull) {
+		t.Errorf("expected errQueueFull, got %v", err)
+	}
+
+	a.release(first)
+	if got := <-order; got != 1 {
+		t.Errorf("expected FIFO order, request %d was served first", got)
+	}
+	if got := <-order; got != 2 {
+		t.Errorf("expected request 2 second, got %d", got)
+	}
+}
+
+func TestAdmission_QueueTimeout(t *testing.T) {
+	a := newTestAdmission(t, &conf.LocationConf{
+		Algorithm:      "RoundRobin",
+		MaxConnections: 1,
+		MaxPending:     1,
+		QueueTimeout:   30 * time.Millisecond,
+		BackendServers: []conf.BackendServer{
+			{Host: "localhost", Port: 8001},
+			{Host: "localhost", Port: 8002},
+		},
+	})
+	server, err := a.acquire(context.Background(), "", nil)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	defer a.release(server)
+
+	start := time.Now()
+	if _, err := a.acquire(context.Background(), "", nil); !errors.Is(err, errQueueTimeout) {
+		t.Errorf("expected errQueueTimeout from location limit, got %v", err)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Error("request should have waited for the queue timeout")
+	}
+	if a.waiters.Len() != 0 {
+		t.Errorf("timed out waiter should leave the queue, %d left", a.waiters.Len())
+	}
+}
+
+func TestAdmission_NoQueueRejectsImmediately(t *testing.T) {
+	a := newTestAdmission(t, &conf.LocationConf{
+		Algorithm: "RoundRobin",
+		BackendServers: []conf.BackendServer{
+			{Host: "localhost", Port: 8001, MaxConnections: 1},
+		},
+	})
+	if _, err := a.acquire(context.Background(), "", nil); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	if _, err := a.acquire(context.Background(), "", nil); !errors.Is(err, errQueueFull) {
+		t.Errorf("expected errQueueFull without max_pending, got %v", err)
+	}
+}
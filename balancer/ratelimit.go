@@ -0,0 +1,171 @@
+package balancer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"load-balancer/conf"
+	"load-balancer/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultAPIKeyHeader = "X-Api-Key"
+
+func newRateLimitStore(cfg conf.RateLimitStoreConf) (ratelimit.IStore, error) {
+	switch cfg.Type {
+	case "", conf.MemoryStore:
+		return ratelimit.NewMemoryStore(0), nil
+	case conf.RedisStore:
+		if cfg.Address == "" {
+			return nil, errors.New("redis rate limit store requires an address")
+		}
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.Address,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})
+		return ratelimit.NewRedisStore(client, ratelimit.NewMemoryStore(0), cfg.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store %q", cfg.Type)
+	}
+}
+
+type rateLimiter struct {
+	name  string
+	conf  conf.RateLimitConf
+	store ratelimit.IStore
+}
+
+func newRateLimiter(name string, rl *conf.RateLimitConf, store ratelimit.IStore) (*rateLimiter, error) {
+	if rl == nil {
+		return nil, nil
+	}
+	if rl.RequestsPerSecond <= 0 {
+		return nil, errors.New("rate_limit requests_per_second must be positive")
+	}
+	switch rl.Key {
+	case "", conf.RateLimitByIP, conf.RateLimitByAPIKey:
+	case conf.RateLimitByHeader:
+		if rl.Header == "" {
+			return nil, errors.New("rate_limit key header requires a header name")
+		}
+	case conf.RateLimitByJWTClaim:
+		if rl.Claim == "" {
+			return nil, errors.New("rate_limit key jwt_claim requires a claim name")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported rate_limit key %q", rl.Key)
+	}
+	return &rateLimiter{name: name, conf: *rl, store: store}, nil
+}
+
+func (l *rateLimiter) allow(r *http.Request) (ratelimit.Result, error) {
+	return l.store.Take(l.name+"|"+l.clientKey(r), ratelimit.Limit{
+		Rate:  l.conf.RequestsPerSecond,
+		Burst: l.conf.Burst,
+	})
+}
+
+// clientKey falls back to the client IP when the configured key is absent from the request.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	var key string
+	switch l.conf.Key {
+	case conf.RateLimitByHeader:
+		key = r.Header.Get(l.conf.Header)
+	case conf.RateLimitByAPIKey:
+		header := l.conf.Header
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		key = r.Header.Get(header)
+		if key == "" {
+			key = r.URL.Query().Get("api_key")
+		}
+	case conf.RateLimitByJWTClaim:
+		key = jwtClaim(r, l.conf.Claim)
+	}
+	if key != "" {
+		return string(l.conf.Key) + ":" + key
+	}
+	return "ip:" + clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// jwtClaim reads a claim from the bearer token without verifying it; it is only used to bucket requests.
+func jwtClaim(r *http.Request, claim string) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	claims := make(map[string]any)
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	value, exists := claims[claim]
+	if !exists || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// applyRateLimits returns false when the request was rejected with 429.
+func (b *Balancer) applyRateLimits(w http.ResponseWriter, r *http.Request, limiters ...*rateLimiter) bool {
+	var tightest *ratelimit.Result
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+		res, err := limiter.allow(r)
+		if err != nil {
+			b.logger.Error(fmt.Sprintf("Rate limit check failed for %s: %v", limiter.name, err))
+			continue
+		}
+		if !res.Allowed {
+			setRateLimitHeaders(w.Header(), res)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			b.logger.Warn(fmt.Sprintf("Rate limit exceeded on %s by %s", limiter.name, limiter.clientKey(r)))
+			return false
+		}
+		if tightest == nil || res.Remaining < tightest.Remaining {
+			tightest = &res
+		}
+	}
+	if tightest != nil {
+		setRateLimitHeaders(w.Header(), *tightest)
+	}
+	return true
+}
+
+func setRateLimitHeaders(h http.Header, res ratelimit.Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"encoding/base64"
+	"load-balancer/conf"
+	"load-balancer/log"
+	"load-balancer/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func newTestBalancer(t *testing.T) *Balancer {
+	cfg := &conf.Conf{Log: conf.LogConf{Logger: conf.JSON, LogPath: path.Join(t.TempDir(), "test.log")}}
+	logger, err := log.NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	return NewBalancer(cfg, logger).(*Balancer)
+}
+
+func TestApplyRateLimits(t *testing.T) {
+	b := newTestBalancer(t)
+	limiter, err := newRateLimiter("example.com:8080/api", &conf.RateLimitConf{RequestsPerSecond: 1, Burst: 2}, ratelimit.NewMemoryStore(0))
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if !b.applyRateLimits(w, r, limiter) {
+			t.Fatalf("request %d should pass", i)
+		}
+		if w.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("expected RateLimit-Limit 2, got %q", w.Header().Get("RateLimit-Limit"))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api", nil)
+	if b.applyRateLimits(w, r, limiter) {
+		t.Fatal("third request should be rejected")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/api", nil)
+	other.RemoteAddr = "198.51.100.1:1234"
+	if !b.applyRateLimits(httptest.NewRecorder(), other, limiter) {
+		t.Error("a different client must have its own bucket")
+	}
+}
+
+func TestRateLimiter_ClientKey(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-42","tier":"free"}`))
+	tests := []struct {
+		name   string
+		conf   conf.RateLimitConf
+		header http.Header
+		target string
+		want   string
+	}{
+		{"ip", conf.RateLimitConf{}, nil, "/", "ip:192.0.2.1"},
+		{"header", conf.RateLimitConf{Key: conf.RateLimitByHeader, Header: "X-Tenant"}, http.Header{"X-Tenant": {"acme"}}, "/", "header:acme"},
+		{"missing header falls back to ip", conf.RateLimitConf{Key: conf.RateLimitByHeader, Header: "X-Tenant"}, nil, "/", "ip:192.0.2.1"},
+		{"api key header", conf.RateLimitConf{Key: conf.RateLimitByAPIKey}, http.Header{"X-Api-Key": {"k1"}}, "/", "api_key:k1"},
+		{"api key query", conf.RateLimitConf{Key: conf.RateLimitByAPIKey}, nil, "/?api_key=k2", "api_key:k2"},
+		{"jwt claim", conf.RateLimitConf{Key: conf.RateLimitByJWTClaim, Claim: "sub"}, http.Header{"Authorization": {"Bearer h." + payload + ".s"}}, "/", "jwt_claim:user-42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.RequestsPerSecond = 1
+			limiter, err := newRateLimiter("test", &tt.conf, nil)
+			if err != nil {
+				t.Fatalf("failed to create limiter: %v", err)
+			}
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if got := limiter.clientKey(r); got != tt.want {
+				t.Errorf("expected key %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
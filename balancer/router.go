@@ -0,0 +1,259 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type matchKind int
+
+const (
+	matchPrefix matchKind = iota
+	matchPreferredPrefix
+	matchExact
+	matchRegex
+	matchParam
+)
+
+const (
+	exactModifier           = "="
+	preferredPrefixModifier = "^~"
+	regexModifier           = "~"
+	regexNoCaseModifier     = "~*"
+)
+
+var (
+	paramSegment   = regexp.MustCompile(`^\{(\w+)\}$`)
+	templateTokens = regexp.MustCompile(`\{(\w+)\}|\$(\d+)`)
+)
+
+// router resolves a request path to a location with nginx precedence:
+//  1. an exact (= /path) match
+//  2. the longest prefix, if it is marked ^~
+//  3. the first regex (~, ~*) or parameterized (/users/{id}) location in config order
+//  4. the longest prefix
+//
+// Prefixes only match on segment boundaries, so /api does not match /apiv2.
+//
+// Several locations may share a path when they carry match blocks; the first one whose
+// conditions hold is used, and a prefix whose locations all fail falls back to a shorter one.
+type router struct {
+	exact    map[string][]*routeHandler
+	root     *trieNode
+	patterns []*patternLocation
+	warnings []string
+}
+
+type patternLocation struct {
+	re      *regexp.Regexp
+	handler *routeHandler
+}
+
+type trieNode struct {
+	children  map[string]*trieNode
+	handlers  []*routeHandler
+	preferred bool
+}
+
+func newRouter() *router {
+	return &router{
+		exact: make(map[string][]*routeHandler),
+		root:  &trieNode{children: make(map[string]*trieNode)},
+	}
+}
+
+func parseLocationPath(raw string) (matchKind, string, error) {
+	kind := matchPrefix
+	p := strings.TrimSpace(raw)
+	if modifier, rest, found := strings.Cut(p, " "); found {
+		rest = strings.TrimSpace(rest)
+		switch modifier {
+		case exactModifier:
+			kind = matchExact
+		case preferredPrefixModifier:
+			kind = matchPreferredPrefix
+		case regexModifier:
+			return matchRegex, rest, nil
+		case regexNoCaseModifier:
+			return matchRegex, "(?i)" + rest, nil
+		default:
+			return 0, "", fmt.Errorf("unsupported location modifier %q", modifier)
+		}
+		p = rest
+	}
+	if !strings.HasPrefix(p, "/") {
+		return 0, "", fmt.Errorf("location path %q must start with /", raw)
+	}
+	if kind == matchPrefix && strings.Contains(p, "{") {
+		return matchParam, path.Clean(p), nil
+	}
+	return kind, path.Clean(p), nil
+}
+
+// compileParamPath turns /users/{id} into a regex matching whole segments, as a prefix.
+func compileParamPath(p string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, segment := range splitPath(p) {
+		pattern.WriteString("/")
+		if m := paramSegment.FindStringSubmatch(segment); m != nil {
+			pattern.WriteString("(?P<" + m[1] + ">[^/]+)")
+			continue
+		}
+		if strings.ContainsAny(segment, "{}") {
+			return nil, fmt.Errorf("parameter in %q must span a whole segment", p)
+		}
+		pattern.WriteString(regexp.QuoteMeta(segment))
+	}
+	pattern.WriteString("(?:/|$)")
+	return regexp.Compile(pattern.String())
+}
+
+func (rt *router) add(raw string, handler *routeHandler) error {
+	kind, p, err := parseLocationPath(raw)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case matchRegex, matchParam:
+		var re *regexp.Regexp
+		if kind == matchRegex {
+			re, err = regexp.Compile(p)
+		} else {
+			re, err = compileParamPath(p)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid location pattern %q: %w", raw, err)
+		}
+		for _, existing := range rt.patterns {
+			if existing.re.String() == re.String() && existing.handler.Match == nil {
+				rt.warnings = append(rt.warnings, fmt.Sprintf("location %q is shadowed by %q and is never matched", raw, existing.handler.Path))
+				return nil
+			}
+		}
+		rt.patterns = append(rt.patterns, &patternLocation{re: re, handler: handler})
+		return nil
+	case matchExact:
+		if shadow := unconditional(rt.exact[p]); shadow != nil {
+			rt.warnings = append(rt.warnings, fmt.Sprintf("location %q is shadowed by %q and is never matched", raw, shadow.Path))
+			return nil
+		}
+		rt.exact[p] = append(rt.exact[p], handler)
+		return nil
+	}
+	node := rt.root
+	for _, segment := range splitPath(p) {
+		child, exists := node.children[segment]
+		if !exists {
+			child = &trieNode{children: make(map[string]*trieNode)}
+			node.children[segment] = child
+		}
+		node = child
+	}
+	preferred := kind == matchPreferredPrefix
+	if len(node.handlers) > 0 && node.preferred != preferred {
+		rt.warnings = append(rt.warnings, fmt.Sprintf("location %q overlaps %q on the same prefix and is never matched", raw, node.handlers[0].Path))
+		return nil
+	}
+	if shadow := unconditional(node.handlers); shadow != nil {
+		rt.warnings = append(rt.warnings, fmt.Sprintf("location %q is shadowed by %q and is never matched", raw, shadow.Path))
+		return nil
+	}
+	node.handlers = append(node.handlers, handler)
+	node.preferred = preferred
+	return nil
+}
+
+func unconditional(handlers []*routeHandler) *routeHandler {
+	for _, handler := range handlers {
+		if handler.Match == nil {
+			return handler
+		}
+	}
+	return nil
+}
+
+func firstMatching(handlers []*routeHandler, r *http.Request) *routeHandler {
+	for _, handler := range handlers {
+		if handler.Match.matches(r) {
+			return handler
+		}
+	}
+	return nil
+}
+
+// match returns the location for cleanPath and the values captured by a regex or parameterized location.
+func (rt *router) match(cleanPath string, r *http.Request) (*routeHandler, map[string]string) {
+	if handler := firstMatching(rt.exact[cleanPath], r); handler != nil {
+		return handler, nil
+	}
+	longest, preferred := rt.longestPrefix(cleanPath, r)
+	if longest != nil && preferred {
+		return longest, nil
+	}
+	for _, pattern := range rt.patterns {
+		if !pattern.handler.Match.matches(r) {
+			continue
+		}
+		if m := pattern.re.FindStringSubmatch(cleanPath); m != nil {
+			return pattern.handler, captures(pattern.re, m)
+		}
+	}
+	return longest, nil
+}
+
+func captures(re *regexp.Regexp, m []string) map[string]string {
+	params := make(map[string]string, len(m))
+	for i, name := range re.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+		params[fmt.Sprint(i)] = m[i]
+		if name != "" {
+			params[name] = m[i]
+		}
+	}
+	return params
+}
+
+// expandTemplate substitutes {name} and $N with captured values; unknown ones expand to empty.
+func expandTemplate(template string, params map[string]string) string {
+	return templateTokens.ReplaceAllStringFunc(template, func(token string) string {
+		m := templateTokens.FindStringSubmatch(token)
+		if m[1] != "" {
+			return params[m[1]]
+		}
+		return params[m[2]]
+	})
+}
+
+// longestPrefix also reports whether the match carries the ^~ modifier.
+func (rt *router) longestPrefix(cleanPath string, r *http.Request) (*routeHandler, bool) {
+	chain := []*trieNode{rt.root}
+	node := rt.root
+	for _, segment := range splitPath(cleanPath) {
+		child, exists := node.children[segment]
+		if !exists {
+			break
+		}
+		node = child
+		chain = append(chain, node)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if handler := firstMatching(chain[i].handlers, r); handler != nil {
+			return handler, chain[i].preferred
+		}
+	}
+	return nil, false
+}
+
+func splitPath(p string) []string {
+	trimmed := strings.Trim(p, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
@@ -0,0 +1,162 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter_Match(t *testing.T) {
+	rt := newRouter()
+	for _, p := range []string{"/", "/api", "= /api/health", "^~ /static", "/api/v1/users"} {
+		if err := rt.add(p, &routeHandler{Path: p}); err != nil {
+			t.Fatalf("failed to add %q: %v", p, err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/api", "/api"},
+		{"/api/orders", "/api"},
+		{"/apiv2", "/"},
+		{"/api/health", "= /api/health"},
+		{"/api/health/deep", "/api"},
+		{"/api/v1/users/42", "/api/v1/users"},
+		{"/api/v1/usersettings", "/api"},
+		{"/static/app.js", "^~ /static"},
+		{"/other", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			handler, _ := rt.match(tt.path, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if handler == nil {
+				t.Fatalf("no match for %s", tt.path)
+			}
+			if handler.Path != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, handler.Path)
+			}
+		})
+	}
+}
+
+func TestRouter_NoRootLocation(t *testing.T) {
+	rt := newRouter()
+	rt.add("/api", &routeHandler{Path: "/api"})
+	if handler, _ := rt.match("/apiv2", httptest.NewRequest(http.MethodGet, "/apiv2", nil)); handler != nil {
+		t.Errorf("expected no match across a segment boundary, got %q", handler.Path)
+	}
+}
+
+func TestRouter_Warnings(t *testing.T) {
+	rt := newRouter()
+	for _, p := range []string{"/api", "^~ /api/", "= /health", "= /health", "/"} {
+		if err := rt.add(p, &routeHandler{Path: p}); err != nil {
+			t.Fatalf("failed to add %q: %v", p, err)
+		}
+	}
+	if len(rt.warnings) != 2 {
+		t.Errorf("expected 2 ambiguity warnings, got %v", rt.warnings)
+	}
+	if handler, _ := rt.match("/api/x", httptest.NewRequest(http.MethodGet, "/api/x", nil)); handler.Path != "/api" {
+		t.Errorf("the first declared location should win, got %q", handler.Path)
+	}
+	if err := rt.add("~~ /x", &routeHandler{}); err == nil {
+		t.Error("expected error for unknown modifier")
+	}
+	if err := rt.add("api", &routeHandler{}); err == nil {
+		t.Error("expected error for relative path")
+	}
+}
+
+func TestRouter_PatternPrecedence(t *testing.T) {
+	rt := newRouter()
+	locations := []string{
+		"/",
+		"/users",
+		"= /users/me",
+		"^~ /users/static",
+		`~ ^/users/(\d+)$`,
+		"/users/{name}/posts/{post}",
+		`~* \.(png|jpg)$`,
+		"/users/{name}",
+	}
+	for _, p := range locations {
+		if err := rt.add(p, &routeHandler{Path: p}); err != nil {
+			t.Fatalf("failed to add %q: %v", p, err)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		want   string
+		params map[string]string
+	}{
+		{"/users/me", "= /users/me", nil},
+		{"/users/static/logo.png", "^~ /users/static", nil},
+		{"/users/42", `~ ^/users/(\d+)$`, map[string]string{"1": "42"}},
+		{"/users/42/avatar.PNG", `~* \.(png|jpg)$`, map[string]string{"1": "PNG"}},
+		{"/users/alice/posts/7", "/users/{name}/posts/{post}", map[string]string{"name": "alice", "post": "7", "1": "alice", "2": "7"}},
+		{"/users/alice/posts/7/comments", "/users/{name}/posts/{post}", map[string]string{"name": "alice", "post": "7", "1": "alice", "2": "7"}},
+		{"/users/alice", "/users/{name}", map[string]string{"name": "alice", "1": "alice"}},
+		{"/users", "/users", nil},
+		{"/usersx", "/", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			handler, params := rt.match(tt.path, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if handler == nil {
+				t.Fatalf("no match for %s", tt.path)
+			}
+			if handler.Path != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, handler.Path)
+			}
+			if len(params) != len(tt.params) {
+				t.Fatalf("expected params %v, got %v", tt.params, params)
+			}
+			for k, v := range tt.params {
+				if params[k] != v {
+					t.Errorf("expected param %s=%q, got %q", k, v, params[k])
+				}
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	params := map[string]string{"id": "42", "1": "42", "2": "posts"}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"/v2/users/{id}", "/v2/users/42"},
+		{"/v2/$2/$1", "/v2/posts/42"},
+		{"user-{id}-{missing}", "user-42-"},
+		{"static", "static"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.template, params); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestRouteHandler_OutgoingRequest(t *testing.T) {
+	h := &routeHandler{
+		Rewrite:    "/internal/users/{id}",
+		SetHeaders: map[string]string{"X-User-Id": "{id}"},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/users/42?full=1", nil)
+	out := h.outgoingRequest(r, map[string]string{"id": "42"})
+	if out.URL.Path != "/internal/users/42" || out.URL.RawQuery != "full=1" {
+		t.Errorf("unexpected rewritten url %s", out.URL)
+	}
+	if out.Header.Get("X-User-Id") != "42" {
+		t.Errorf("expected injected header, got %v", out.Header)
+	}
+	if r.URL.Path != "/users/42" || r.Header.Get("X-User-Id") != "" {
+		t.Error("the incoming request must not be modified")
+	}
+}
@@ -0,0 +1,213 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// trafficSplit spreads a location's requests over named backend groups. Weights are swapped as
+// a whole so a reload never mixes old and new shares.
+type trafficSplit struct {
+	conf    conf.SplitConf
+	groups  []*backendGroup
+	weights atomic.Pointer[[]int]
+}
+
+type backendGroup struct {
+	name      string
+	admission *admission
+	servers   []algs.IBackendServer
+}
+
+func newTrafficSplit(loc *conf.LocationConf, transport http.RoundTripper) (*trafficSplit, error) {
+	if len(loc.Groups) == 0 {
+		if loc.Split != nil {
+			return nil, fmt.Errorf("split requires groups")
+		}
+		return nil, nil
+	}
+	if len(loc.BackendServers) > 0 {
+		return nil, fmt.Errorf("backend_servers and groups are mutually exclusive")
+	}
+	split := &trafficSplit{}
+	if loc.Split != nil {
+		split.conf = *loc.Split
+	}
+	names := make(map[string]bool, len(loc.Groups))
+	for _, group := range loc.Groups {
+		if group.Name == "" || names[group.Name] {
+			return nil, fmt.Errorf("group names must be unique and non-empty, got %q", group.Name)
+		}
+		names[group.Name] = true
+		groupLoc := *loc
+		groupLoc.Groups = nil
+		groupLoc.BackendServers = group.BackendServers
+		if group.Algorithm != "" {
+			groupLoc.Algorithm = group.Algorithm
+		}
+		alg, err := algs.NewAlgorithm(&groupLoc, transport)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", group.Name, err)
+		}
+		servers, _ := alg.AllServers()
+		split.groups = append(split.groups, &backendGroup{
+			name:      group.Name,
+			admission: newAdmission(&groupLoc, alg),
+			servers:   servers,
+		})
+	}
+	if err := split.setWeights(loc.Groups); err != nil {
+		return nil, err
+	}
+	return split, nil
+}
+
+// setWeights applies reloaded weights; the set of groups itself only changes on restart.
+func (s *trafficSplit) setWeights(groups []conf.BackendGroupConf) error {
+	if len(groups) != len(s.groups) {
+		return fmt.Errorf("groups changed from %d to %d entries", len(s.groups), len(groups))
+	}
+	weights := make([]int, len(s.groups))
+	total := 0
+	for i, group := range groups {
+		if group.Name != s.groups[i].name {
+			return fmt.Errorf("group %s was renamed to %s", s.groups[i].name, group.Name)
+		}
+		if group.Weight < 0 {
+			return fmt.Errorf("group %s has a negative weight", group.Name)
+		}
+		weights[i] = group.Weight
+		total += group.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one group needs a positive weight")
+	}
+	s.weights.Store(&weights)
+	return nil
+}
+
+// pick returns the group for r. A pinned backend keeps its group while that group still gets
+// traffic, so draining a canary to weight 0 also moves its sticky clients.
+func (s *trafficSplit) pick(r *http.Request, pinned algs.IBackendServer) *backendGroup {
+	weights := *s.weights.Load()
+	if group := s.named(r); group != nil {
+		return group
+	}
+	if pinned != nil {
+		for i, group := range s.groups {
+			if weights[i] > 0 && group.has(pinned) {
+				return group
+			}
+		}
+	}
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	var n int
+	if key := s.userKey(r); key != "" {
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		n = int(hash.Sum64() % uint64(total))
+	} else {
+		n = rand.IntN(total)
+	}
+	for i, weight := range weights {
+		if n < weight {
+			return s.groups[i]
+		}
+		n -= weight
+	}
+	return s.groups[len(s.groups)-1]
+}
+
+// named returns the group forced by the override header or cookie, regardless of its weight.
+func (s *trafficSplit) named(r *http.Request) *backendGroup {
+	var name string
+	if s.conf.Header != "" {
+		name = r.Header.Get(s.conf.Header)
+	}
+	if name == "" && s.conf.Cookie != "" {
+		if cookie, err := r.Cookie(s.conf.Cookie); err == nil {
+			name = cookie.Value
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	for _, group := range s.groups {
+		if strings.EqualFold(group.name, name) {
+			return group
+		}
+	}
+	return nil
+}
+
+func (s *trafficSplit) userKey(r *http.Request) string {
+	if s.conf.HashHeader != "" {
+		if key := r.Header.Get(s.conf.HashHeader); key != "" {
+			return key
+		}
+	}
+	if s.conf.HashCookie != "" {
+		if cookie, err := r.Cookie(s.conf.HashCookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (s *trafficSplit) servers() []algs.IBackendServer {
+	var servers []algs.IBackendServer
+	for _, group := range s.groups {
+		servers = append(servers, group.servers...)
+	}
+	return servers
+}
+
+func (g *backendGroup) has(server algs.IBackendServer) bool {
+	for _, s := range g.servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadSplits applies group weights from a changed config file.
+func (b *Balancer) reloadSplits(c *conf.Conf, err error) {
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("Failed to reload config: %v", err))
+		return
+	}
+	for _, proxy := range c.Proxies {
+		proxyName := fmt.Sprintf("%s:%d", proxy.Host, proxy.Port)
+		for i, loc := range proxy.Locations {
+			if len(loc.Groups) == 0 {
+				continue
+			}
+			key := splitKey(proxyName, i, loc.Path)
+			split, exists := b.splits[key]
+			if !exists {
+				b.logger.Warn(fmt.Sprintf("Ignoring new groups on %s%s until restart", proxyName, loc.Path))
+				continue
+			}
+			if err := split.setWeights(loc.Groups); err != nil {
+				b.logger.Warn(fmt.Sprintf("Kept previous split weights for %s%s: %v", proxyName, loc.Path, err))
+				continue
+			}
+			b.logger.Info(fmt.Sprintf("Updated split weights for %s%s to %v", proxyName, loc.Path, *split.weights.Load()))
+		}
+	}
+}
+
+// splitKey identifies a location across reloads by its proxy, position and path.
+func splitKey(proxyName string, index int, path string) string {
+	return fmt.Sprintf("%s%s#%d", proxyName, path, index)
+}
@@ -0,0 +1,107 @@
+package balancer
+
+import (
+	"fmt"
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newSplitProxy(t *testing.T, stable, canary int) conf.ProxyConf {
+	t.Helper()
+	return conf.ProxyConf{Port: 8080, Host: "example.com", Locations: []conf.LocationConf{{
+		Path:      "/",
+		Algorithm: "RoundRobin",
+		Split:     &conf.SplitConf{Header: "X-Group", HashHeader: "X-User-ID"},
+		Groups: []conf.BackendGroupConf{
+			{Name: "stable", Weight: stable, BackendServers: []conf.BackendServer{newNamedBackend(t, "stable")}},
+			{Name: "canary", Weight: canary, Algorithm: "Random", BackendServers: []conf.BackendServer{newNamedBackend(t, "canary")}},
+		},
+	}}}
+}
+
+func TestProxyRequest_TrafficSplit(t *testing.T) {
+	b := newTestBalancer(t)
+	proxy := newSplitProxy(t, 100, 0)
+	if err := b.registerProxy(proxy); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	send := func(header http.Header) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, values := range header {
+			for _, v := range values {
+				r.Header.Add(k, v)
+			}
+		}
+		handler, _ := b.hostRouter[8080].match("example.com").router.match("/", r)
+		w := httptest.NewRecorder()
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+		return w.Body.String()
+	}
+
+	for range 10 {
+		if got := send(nil); got != "stable" {
+			t.Fatalf("expected stable with weights 100/0, got %s", got)
+		}
+	}
+	if got := send(http.Header{"X-Group": {"canary"}}); got != "canary" {
+		t.Errorf("expected the override header to reach canary at weight 0, got %s", got)
+	}
+
+	proxy.Locations[0].Groups[0].Weight = 0
+	proxy.Locations[0].Groups[1].Weight = 100
+	b.reloadSplits(&conf.Conf{Proxies: []conf.ProxyConf{proxy}}, nil)
+	for range 10 {
+		if got := send(nil); got != "canary" {
+			t.Fatalf("expected canary after reloading weights 0/100, got %s", got)
+		}
+	}
+
+	proxy.Locations[0].Groups[0].Weight = 50
+	proxy.Locations[0].Groups[1].Weight = 50
+	b.reloadSplits(&conf.Conf{Proxies: []conf.ProxyConf{proxy}}, nil)
+	seen := map[string]bool{}
+	for user := range 10 {
+		id := http.Header{"X-User-ID": {fmt.Sprint("user-", user)}}
+		group := send(id)
+		if got := send(id); got != group {
+			t.Fatalf("user-%d moved from %s to %s", user, group, got)
+		}
+		seen[group] = true
+	}
+	if !seen["stable"] || !seen["canary"] {
+		t.Errorf("expected 50/50 to bucket users into both groups, got %v", seen)
+	}
+}
+
+func TestTrafficSplit_RejectsInvalidWeights(t *testing.T) {
+	b := newTestBalancer(t)
+	proxy := newSplitProxy(t, 90, 10)
+	if err := b.registerProxy(proxy); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	split := b.splits[splitKey("example.com:8080", 0, "/")]
+	tests := []struct {
+		name   string
+		groups []conf.BackendGroupConf
+	}{
+		{"all zero", []conf.BackendGroupConf{{Name: "stable"}, {Name: "canary"}}},
+		{"negative", []conf.BackendGroupConf{{Name: "stable", Weight: 100}, {Name: "canary", Weight: -1}}},
+		{"renamed", []conf.BackendGroupConf{{Name: "stable", Weight: 50}, {Name: "beta", Weight: 50}}},
+		{"removed", []conf.BackendGroupConf{{Name: "stable", Weight: 100}}},
+	}
+	for _, tt := range tests {
+		if err := split.setWeights(tt.groups); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+	if weights := *split.weights.Load(); weights[0] != 90 || weights[1] != 10 {
+		t.Errorf("expected the original weights to be kept, got %v", weights)
+	}
+
+	proxy.Locations[0].BackendServers = []conf.BackendServer{{Host: "localhost", Port: 8001}}
+	if _, err := newTrafficSplit(&proxy.Locations[0], nil); err == nil {
+		t.Error("expected error when backend_servers and groups are both set")
+	}
+}
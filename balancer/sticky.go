@@ -0,0 +1,150 @@
+package balancer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const defaultStickyCookie = "lb_sticky"
+
+var sameSiteModes = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// stickySessions maps the token a client presents back to the backend that issued it.
+type stickySessions struct {
+	conf     conf.StickyConf
+	sameSite http.SameSite
+	servers  map[uuid.UUID]algs.IBackendServer
+}
+
+func newStickySessions(c *conf.StickyConf, servers []algs.IBackendServer) (*stickySessions, error) {
+	if c == nil {
+		return nil, nil
+	}
+	sameSite, ok := sameSiteModes[strings.ToLower(c.SameSite)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported same_site %q", c.SameSite)
+	}
+	if sameSite == http.SameSiteNoneMode && !c.Secure {
+		return nil, fmt.Errorf("same_site none requires secure")
+	}
+	s := &stickySessions{conf: *c, sameSite: sameSite, servers: make(map[uuid.UUID]algs.IBackendServer)}
+	if s.conf.Cookie == "" {
+		s.conf.Cookie = defaultStickyCookie
+	}
+	if s.conf.Path == "" {
+		s.conf.Path = "/"
+	}
+	for _, server := range servers {
+		s.servers[server.GetID()] = server
+	}
+	return s, nil
+}
+
+// pinned returns the backend named by the request's header or cookie while it is healthy. Forged,
+// stale or unknown tokens are ignored so the algorithm picks a new backend.
+func (s *stickySessions) pinned(r *http.Request) algs.IBackendServer {
+	if s == nil {
+		return nil
+	}
+	token := ""
+	if s.conf.Header != "" {
+		token = r.Header.Get(s.conf.Header)
+	}
+	if token == "" {
+		if cookie, err := r.Cookie(s.conf.Cookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	id, ok := s.verify(token)
+	if !ok {
+		return nil
+	}
+	server, exists := s.servers[id]
+	if !exists || server.GetStatus() != algs.Healthy {
+		return nil
+	}
+	return server
+}
+
+// inActiveTier reports whether server is in the tier alg currently sends traffic to, so clients
+// pinned to a backup during a failover move back once the primary tier recovers.
+func inActiveTier(alg algs.IAlgorithm, server algs.IBackendServer) bool {
+	tiered, ok := alg.(*algs.TieredAlgorithm)
+	if !ok {
+		return true
+	}
+	tier := tiered.ActiveTier()
+	if tier == nil {
+		return false
+	}
+	servers, _ := tier.AllServers()
+	return slices.Contains(servers, server)
+}
+
+// pin hands the client a token for server unless its request already carried a valid one.
+func (s *stickySessions) pin(w http.ResponseWriter, r *http.Request, server algs.IBackendServer) {
+	if s == nil {
+		return
+	}
+	token := s.token(server.GetID())
+	if s.conf.Header != "" {
+		w.Header().Set(s.conf.Header, token)
+	}
+	if cookie, err := r.Cookie(s.conf.Cookie); err == nil && cookie.Value == token {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.conf.Cookie,
+		Value:    token,
+		Path:     s.conf.Path,
+		MaxAge:   int(s.conf.TTL.Seconds()),
+		Secure:   s.conf.Secure,
+		HttpOnly: s.conf.HTTPOnly,
+		SameSite: s.sameSite,
+	})
+}
+
+func (s *stickySessions) token(id uuid.UUID) string {
+	if s.conf.Secret == "" {
+		return id.String()
+	}
+	return id.String() + "." + base64.RawURLEncoding.EncodeToString(s.sign(id))
+}
+
+func (s *stickySessions) verify(token string) (uuid.UUID, bool) {
+	raw, signature, signed := strings.Cut(token, ".")
+	if signed != (s.conf.Secret != "") {
+		return uuid.UUID{}, false
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.UUID{}, false
+	}
+	if signed {
+		mac, err := base64.RawURLEncoding.DecodeString(signature)
+		if err != nil || !hmac.Equal(mac, s.sign(id)) {
+			return uuid.UUID{}, false
+		}
+	}
+	return id, true
+}
+
+func (s *stickySessions) sign(id uuid.UUID) []byte {
+	mac := hmac.New(sha256.New, []byte(s.conf.Secret))
+	mac.Write(id[:])
+	return mac.Sum(nil)
+}
@@ -0,0 +1,180 @@
+package balancer
+
+import (
+	"io"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newStickyHandler(t *testing.T, sticky *conf.StickyConf, names ...string) *routeHandler {
+	t.Helper()
+	loc := conf.LocationConf{Path: "/", Algorithm: string(algs.RoundRobin), Sticky: sticky}
+	for _, name := range names {
+		loc.BackendServers = append(loc.BackendServers, newNamedBackend(t, name))
+	}
+	alg, err := algs.NewAlgorithm(&loc, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	servers, _ := alg.AllServers()
+	s, err := newStickySessions(loc.Sticky, servers)
+	if err != nil {
+		t.Fatalf("failed to create sticky sessions: %v", err)
+	}
+	return &routeHandler{Path: "/", Alg: alg, Transport: http.DefaultTransport, Admission: newAdmission(&loc, alg), Sticky: s}
+}
+
+// newNamedBackend starts a backend that answers every request with its name.
+func newNamedBackend(t *testing.T, name string) conf.BackendServer {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(backend.Close)
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return conf.BackendServer{Host: u.Hostname(), Port: port}
+}
+
+func TestProxyRequest_StickyCookie(t *testing.T) {
+	handler := newStickyHandler(t, &conf.StickyConf{Secret: "s3cret", TTL: time.Hour, HTTPOnly: true, SameSite: "Lax"}, "a", "b", "c")
+	b := newTestBalancer(t)
+	send := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+		return w
+	}
+
+	first := send(nil)
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultStickyCookie {
+		t.Fatalf("expected a %s cookie, got %v", defaultStickyCookie, cookies)
+	}
+	if !cookies[0].HttpOnly || cookies[0].MaxAge != 3600 || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie attributes not applied: %+v", cookies[0])
+	}
+	pinned := first.Body.String()
+	for range 5 {
+		w := send(cookies[0])
+		if w.Body.String() != pinned {
+			t.Fatalf("expected pinned backend %s, got %s", pinned, w.Body.String())
+		}
+		if len(w.Result().Cookies()) != 0 {
+			t.Error("expected no new cookie while the pinned backend serves")
+		}
+	}
+
+	forged := &http.Cookie{Name: defaultStickyCookie, Value: cookies[0].Value[:36] + ".AAAA"}
+	if got := handler.Sticky.pinned(requestWithCookie(forged)); got != nil {
+		t.Errorf("expected a forged signature to be ignored, got %s", got.GetUrl())
+	}
+
+	server := handler.Sticky.pinned(requestWithCookie(cookies[0]))
+	server.SetStatus(algs.UnHealthy)
+	defer server.SetStatus(algs.Healthy)
+	if got := handler.Sticky.pinned(requestWithCookie(cookies[0])); got != nil {
+		t.Error("expected an unhealthy backend to release its clients")
+	}
+}
+
+func TestProxyRequest_StickyHeader(t *testing.T) {
+	handler := newStickyHandler(t, &conf.StickyConf{Header: "X-Backend-Token"}, "a", "b")
+	b := newTestBalancer(t)
+
+	w := httptest.NewRecorder()
+	b.proxyRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "example.com", "/", handler, nil)
+	token := w.Header().Get("X-Backend-Token")
+	if token == "" {
+		t.Fatal("expected the token in a response header")
+	}
+	pinned := w.Body.String()
+	for range 3 {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Backend-Token", token)
+		w := httptest.NewRecorder()
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+		if w.Body.String() != pinned {
+			t.Fatalf("expected pinned backend %s, got %s", pinned, w.Body.String())
+		}
+	}
+}
+
+func TestNewStickySessions_Validates(t *testing.T) {
+	if _, err := newStickySessions(&conf.StickyConf{SameSite: "sometimes"}, nil); err == nil {
+		t.Error("expected error for an unknown same_site")
+	}
+	if _, err := newStickySessions(&conf.StickyConf{SameSite: "none"}, nil); err == nil {
+		t.Error("expected error for same_site none without secure")
+	}
+}
+
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	return r
+}
+
+// switchableTier reports no healthy servers while down, like a tier whose health checks fail.
+type switchableTier struct {
+	algs.IAlgorithm
+	down bool
+}
+
+func (t *switchableTier) HealthyServers() ([]algs.IBackendServer, error) {
+	if t.down {
+		return nil, nil
+	}
+	return t.IAlgorithm.HealthyServers()
+}
+
+func TestProxyRequest_StickyFailsBackToPrimaryTier(t *testing.T) {
+	t.Setenv("RUN_TYPE", "test")
+	loc := conf.LocationConf{Path: "/", Algorithm: string(algs.RoundRobin), Sticky: &conf.StickyConf{}}
+	loc.BackendServers = []conf.BackendServer{newNamedBackend(t, "primary"), newNamedBackend(t, "backup")}
+	loc.BackendServers[1].Backup = true
+	alg, err := algs.NewAlgorithm(&loc, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	tiered := alg.(*algs.TieredAlgorithm)
+	primary := &switchableTier{IAlgorithm: tiered.Tiers[0], down: true}
+	tiered.Tiers[0] = primary
+	servers, _ := alg.AllServers()
+	sticky, _ := newStickySessions(loc.Sticky, servers)
+	handler := &routeHandler{Path: "/", Alg: alg, Transport: http.DefaultTransport, Admission: newAdmission(&loc, alg), Sticky: sticky}
+	b := newTestBalancer(t)
+	send := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+		return w
+	}
+
+	failover := send(nil)
+	if failover.Body.String() != "backup" {
+		t.Fatalf("expected the backup while the primary tier is down, got %q", failover.Body.String())
+	}
+	cookie := failover.Result().Cookies()[0]
+
+	primary.down = false
+	failback := send(cookie)
+	if failback.Body.String() != "primary" {
+		t.Errorf("expected the pinned client back on the primary tier, got %q", failback.Body.String())
+	}
+	if cookies := failback.Result().Cookies(); len(cookies) != 1 || cookies[0].Value == cookie.Value {
+		t.Errorf("expected the client to be re-pinned to the primary, got %v", cookies)
+	}
+}
@@ -0,0 +1,246 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultConnectTimeout = 10 * time.Second
+
+// streamPool balances whole connections across a stream proxy's backends.
+type streamPool struct {
+	name           string
+	admission      *admission
+	connectTimeout time.Duration
+	idleTimeout    time.Duration
+}
+
+func (b *Balancer) newStreamPool(proxy conf.ProxyConf) (*streamPool, error) {
+	if len(proxy.Locations) > 0 {
+		return nil, fmt.Errorf("mode %s uses backend_servers, not locations", proxy.Mode)
+	}
+	if proxy.TLS.Enabled() {
+		return nil, fmt.Errorf("mode %s cannot terminate TLS", proxy.Mode)
+	}
+	scheme := "tcp"
+	if proxy.Mode == conf.ModeUDP {
+		scheme = "udp"
+	}
+	loc := conf.LocationConf{
+		Algorithm:      proxy.Algorithm,
+		Scheme:         scheme,
+		Zone:           b.conf.Zone,
+		BackendServers: proxy.BackendServers,
+	}
+	alg, err := algs.NewAlgorithm(&loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	connectTimeout := proxy.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	return &streamPool{
+		name:           fmt.Sprintf("%s:%d", proxy.Host, proxy.Port),
+		admission:      newAdmission(&loc, alg),
+		connectTimeout: connectTimeout,
+		idleTimeout:    proxy.IdleTimeout,
+	}, nil
+}
+
+func (b *Balancer) registerStreamProxy(vhost *virtualHost, proxy conf.ProxyConf) error {
+	if vhost.pool != nil {
+		return fmt.Errorf("host %q is already routed on port %d", proxy.Host, proxy.Port)
+	}
+	pool, err := b.newStreamPool(proxy)
+	if err != nil {
+		return err
+	}
+	if proxy.Mode == conf.ModeTCP {
+		// a plain TCP stream carries no name to route by, so the port has exactly one pool
+		hosts := b.hostRouter[proxy.Port]
+		if hosts.defaultHost != nil && hosts.defaultHost != vhost {
+			return fmt.Errorf("port %d already has a tcp proxy for host %q", proxy.Port, hosts.defaultHost.name)
+		}
+		hosts.defaultHost = vhost
+	}
+	vhost.pool = pool
+	return nil
+}
+
+// portMode checks that every proxy sharing a listener uses the same mode.
+func (b *Balancer) portMode(port int) (conf.ProxyMode, error) {
+	proxies := b.getProxiesByPort(port)
+	mode := proxyMode(proxies[0])
+	for _, proxy := range proxies[1:] {
+		if proxyMode(proxy) != mode {
+			return "", fmt.Errorf("proxies on port %d disagree on mode", port)
+		}
+	}
+	return mode, nil
+}
+
+func proxyMode(proxy conf.ProxyConf) conf.ProxyMode {
+	if proxy.Mode == "" {
+		return conf.ModeHTTP
+	}
+	return proxy.Mode
+}
+
+func (b *Balancer) serveStream(port int, hosts *hostMatcher, mode conf.ProxyMode, proxyProtocol bool) {
+	addr := fmt.Sprintf(":%d", port)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("Failed to listen on port %d: %v", port, err))
+		return
+	}
+	b.trackListener(ln)
+	if proxyProtocol {
+		b.logger.Info(fmt.Sprintf("Accepting PROXY protocol headers on %s", addr))
+		ln = newProxyProtoListener(ln, proxyHeaderTimeout)
+	}
+	b.logger.Info(fmt.Sprintf("Listening in %s mode on %s", mode, addr))
+	b.acceptStreams(ln, hosts, mode)
+}
+
+func (b *Balancer) acceptStreams(ln net.Listener, hosts *hostMatcher, mode conf.ProxyMode) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			b.logger.Error(fmt.Sprintf("Accept error on %s: %v", ln.Addr(), err))
+			continue
+		}
+		go b.handleStream(conn, hosts, mode)
+	}
+}
+
+func (b *Balancer) handleStream(conn net.Conn, hosts *hostMatcher, mode conf.ProxyMode) {
+	defer conn.Close()
+	if mode == conf.ModeTCP {
+		b.forwardStream(conn, hosts.defaultHost.pool)
+		return
+	}
+	conn, serverName, err := peekServerName(conn)
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("Failed to read TLS ClientHello from %s: %v", conn.RemoteAddr(), err))
+		return
+	}
+	vhost := hosts.match(serverName)
+	if vhost == nil || vhost.pool == nil {
+		b.logger.Warn(fmt.Sprintf("No passthrough route for server name %q from %s", serverName, conn.RemoteAddr()))
+		return
+	}
+	b.forwardStream(conn, vhost.pool)
+}
+
+// forwardStream connects the client to a backend chosen by the pool, keyed by client IP for
+// algorithms that support it.
+func (b *Balancer) forwardStream(client net.Conn, pool *streamPool) {
+	clientIP, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), pool.connectTimeout)
+	defer cancel()
+	server, err := pool.admission.acquire(ctx, clientIP, nil)
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("[%s] no backend for %s: %v", pool.name, client.RemoteAddr(), err))
+		return
+	}
+	defer pool.admission.release(server)
+	target, err := url.Parse(server.GetUrl())
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("Invalid backend URL %s: %v", server.GetUrl(), err))
+		return
+	}
+	var dialer net.Dialer
+	upstream, err := dialer.DialContext(ctx, "tcp", target.Host)
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("[%s] failed to connect to %s: %v", pool.name, target.Host, err))
+		return
+	}
+	defer upstream.Close()
+	start := time.Now()
+	sent, received := pipe(client, upstream, pool.idleTimeout)
+	b.logger.Info(fmt.Sprintf("[%s] %s -> %s closed after %s, %d bytes sent, %d bytes received",
+		pool.name, client.RemoteAddr(), target.Host, time.Since(start).Round(time.Millisecond), sent, received))
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// pipe copies both directions until each side has finished sending and returns the bytes sent
+// to and received from upstream. When one side closes its write half, the other side is told
+// through CloseWrite so half-closed protocols keep working. With an idle timeout, the streams
+// are closed once neither direction has carried data for that long.
+func pipe(client, upstream net.Conn, idleTimeout time.Duration) (int64, int64) {
+	var (
+		wg           sync.WaitGroup
+		lastActivity atomic.Int64
+		sent         int64
+		received     int64
+	)
+	lastActivity.Store(time.Now().UnixNano())
+	copyHalf := func(dst, src net.Conn, n *int64) {
+		defer wg.Done()
+		var err error
+		*n, err = copyIdle(dst, src, idleTimeout, &lastActivity)
+		if err != nil {
+			client.Close()
+			upstream.Close()
+			return
+		}
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(upstream, client, &sent)
+	go copyHalf(client, upstream, &received)
+	wg.Wait()
+	return sent, received
+}
+
+// copyIdle copies until EOF. A read deadline only ends the copy when the other direction has
+// been quiet too, so a long one-way transfer is not mistaken for an idle connection.
+func copyIdle(dst, src net.Conn, idleTimeout time.Duration, lastActivity *atomic.Int64) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		if idleTimeout > 0 {
+			src.SetReadDeadline(time.Unix(0, lastActivity.Load()).Add(idleTimeout))
+		}
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr == nil {
+			continue
+		}
+		if errors.Is(rerr, io.EOF) {
+			return written, nil
+		}
+		if errors.Is(rerr, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, lastActivity.Load())) < idleTimeout {
+			continue
+		}
+		return written, rerr
+	}
+}
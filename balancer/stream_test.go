@@ -0,0 +1,168 @@
+package balancer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"load-balancer/conf"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTLSBackend(t *testing.T, ca *testCA, name string) conf.BackendServer {
+	t.Helper()
+	dir := t.TempDir()
+	certPath, keyPath := ca.issue(t, dir, name, time.Now().Add(time.Hour))
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("failed to load key pair: %v", err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+	backend.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return conf.BackendServer{Host: u.Hostname(), Port: port}
+}
+
+func TestTLSPassthrough_RoutesBySNI(t *testing.T) {
+	ca := newTestCA(t, t.TempDir())
+	b := newTestBalancer(t)
+	b.conf.Proxies = []conf.ProxyConf{
+		{Port: 8443, Host: "a.example.com", Mode: conf.ModeTLSPassthrough, Algorithm: "RoundRobin", BackendServers: []conf.BackendServer{newTLSBackend(t, ca, "a.example.com")}},
+		{Port: 8443, Host: "b.example.com", Mode: conf.ModeTLSPassthrough, Algorithm: "RoundRobin", BackendServers: []conf.BackendServer{newTLSBackend(t, ca, "b.example.com")}},
+	}
+	for _, proxy := range b.conf.Proxies {
+		if err := b.registerProxy(proxy); err != nil {
+			t.Fatalf("failed to register proxy: %v", err)
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go b.acceptStreams(ln, b.hostRouter[8443], conf.ModeTLSPassthrough)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: host, RootCAs: roots})
+		if err != nil {
+			t.Fatalf("%s: handshake through passthrough failed: %v", host, err)
+		}
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("%s: failed to read response: %v", host, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		conn.Close()
+		if string(body) != host {
+			t.Errorf("expected backend %s to answer, got %q", host, body)
+		}
+	}
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "c.example.com", RootCAs: roots})
+	if err == nil {
+		conn.Close()
+		t.Error("expected unknown server name to be refused")
+	}
+}
+
+func TestRegisterProxy_StreamModeValidation(t *testing.T) {
+	b := newTestBalancer(t)
+	invalid := []conf.ProxyConf{
+		{Port: 8443, Host: "a.example.com", Mode: conf.ModeTLSPassthrough, TLS: conf.TLSOn},
+		{Port: 8444, Host: "a.example.com", Mode: conf.ModeTLSPassthrough, Locations: []conf.LocationConf{{Path: "/"}}},
+		{Port: 8445, Host: "a.example.com", Mode: "smtp"},
+	}
+	for _, proxy := range invalid {
+		if err := b.registerProxy(proxy); err == nil {
+			t.Errorf("expected %+v to be rejected", proxy)
+		}
+	}
+	b.conf.Proxies = []conf.ProxyConf{
+		{Port: 8446, Host: "a.example.com", Mode: conf.ModeTLSPassthrough},
+		{Port: 8446, Host: "b.example.com"},
+	}
+	if _, err := b.portMode(8446); err == nil {
+		t.Error("expected mixed modes on one port to be rejected")
+	}
+}
+
+func newTCPBackend(t *testing.T, handle func(net.Conn)) conf.BackendServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return conf.BackendServer{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func TestTCPMode_HalfCloseAndIdleTimeout(t *testing.T) {
+	backend := newTCPBackend(t, func(conn net.Conn) {
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		fmt.Fprintf(conn, "got %d bytes", len(data))
+	})
+	b := newTestBalancer(t)
+	proxy := conf.ProxyConf{Port: 5432, Mode: conf.ModeTCP, Algorithm: "RoundRobin", IdleTimeout: 200 * time.Millisecond, BackendServers: []conf.BackendServer{backend}}
+	b.conf.Proxies = []conf.ProxyConf{proxy}
+	if err := b.registerProxy(proxy); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	if err := b.registerProxy(conf.ProxyConf{Port: 5432, Host: "other", Mode: conf.ModeTCP, BackendServers: []conf.BackendServer{backend}}); err == nil {
+		t.Error("expected a second tcp proxy on the same port to be rejected")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go b.acceptStreams(ln, b.hostRouter[5432], conf.ModeTCP)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn.Write([]byte("hello"))
+	conn.(*net.TCPConn).CloseWrite()
+	reply, _ := io.ReadAll(conn)
+	conn.Close()
+	if string(reply) != "got 5 bytes" {
+		t.Errorf("expected the backend to answer after half-close, got %q", reply)
+	}
+
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := idle.Read(make([]byte, 1)); err == nil || time.Since(start) > 3*time.Second {
+		t.Errorf("expected the idle connection to be closed by the balancer, got %v after %s", err, time.Since(start))
+	}
+}
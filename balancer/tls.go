@@ -0,0 +1,175 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"load-balancer/conf"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthModes = map[conf.ClientAuthMode]tls.ClientAuthType{
+	conf.ClientAuthNone:             tls.NoClientCert,
+	conf.ClientAuthRequest:          tls.RequestClientCert,
+	conf.ClientAuthRequire:          tls.RequireAnyClientCert,
+	conf.ClientAuthVerifyIfGiven:    tls.VerifyClientCertIfGiven,
+	conf.ClientAuthRequireAndVerify: tls.RequireAndVerifyClientCert,
+}
+
+// clientAuthMode keeps the old behaviour of always requiring verified client certs when a CA is
+// configured without an explicit client_auth.
+func clientAuthMode(proxy conf.ProxyConf) (tls.ClientAuthType, error) {
+	mode := proxy.ClientAuth
+	if mode == "" {
+		mode = conf.ClientAuthNone
+		if proxy.ClientCA != "" {
+			mode = conf.ClientAuthRequireAndVerify
+		}
+	}
+	authType, ok := clientAuthModes[mode]
+	if !ok {
+		return 0, fmt.Errorf("unsupported client_auth %q", proxy.ClientAuth)
+	}
+	if verifiesClientCerts(authType) && proxy.ClientCA == "" {
+		return 0, fmt.Errorf("client_auth %s requires certificate_ca", mode)
+	}
+	return authType, nil
+}
+
+func verifiesClientCerts(authType tls.ClientAuthType) bool {
+	return authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert
+}
+
+func (b *Balancer) buildTLSConfig(proxy conf.ProxyConf) (*tls.Config, error) {
+	authType, err := clientAuthMode(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	var caCertPool *x509.CertPool
+	if proxy.ClientCA != "" {
+		caCertPool = x509.NewCertPool()
+		caCert, err := os.ReadFile(proxy.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA cert: %w", err)
+		}
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", proxy.ClientCA)
+		}
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if proxy.MinTLSVersion != "" {
+		version, ok := tlsVersions[proxy.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported min_tls_version %q", proxy.MinTLSVersion)
+		}
+		minVersion = version
+	}
+	config := &tls.Config{
+		ClientAuth: authType,
+		ClientCAs:  caCertPool,
+		MinVersion: minVersion,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if proxy.TLS == conf.TLSAuto {
+		manager, err := b.acmeManager()
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = manager.GetCertificate
+		return config, nil
+	}
+	cert, err := tls.LoadX509KeyPair(proxy.Certificate, proxy.CertificateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server cert/key: %w", err)
+	}
+	config.Certificates = []tls.Certificate{cert}
+	return config, nil
+}
+
+// portTLSConfig selects each host's own TLS config by SNI, so hosts sharing a port keep their
+// certificate, client CA policy and minimum version. Clients without a matching SNI get the
+// default host's config, or the first TLS proxy's on that port.
+func (b *Balancer) portTLSConfig(port int, hosts *hostMatcher) (*tls.Config, error) {
+	proxies := b.getProxiesByPort(port)
+	for _, proxy := range proxies[1:] {
+		if proxy.TLS.Enabled() != proxies[0].TLS.Enabled() {
+			return nil, fmt.Errorf("port %d mixes TLS and plain HTTP proxies", port)
+		}
+	}
+	if !proxies[0].TLS.Enabled() {
+		return nil, nil
+	}
+	return &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if b.acme != nil && isACMEChallenge(hello) {
+				return b.acme.TLSConfig(), nil
+			}
+			return hosts.tlsConfigFor(hello.ServerName), nil
+		},
+	}, nil
+}
+
+func (m *hostMatcher) tlsConfigFor(serverName string) *tls.Config {
+	return m.tlsFor(serverName).current()
+}
+
+// tlsFor returns the reloader whose config completes a handshake for serverName.
+func (m *hostMatcher) tlsFor(serverName string) *certReloader {
+	if serverName != "" {
+		if vhost := m.match(serverName); vhost != nil && vhost.tls != nil {
+			return vhost.tls
+		}
+	}
+	if m.defaultHost != nil && m.defaultHost.tls != nil {
+		return m.defaultHost.tls
+	}
+	return m.fallbackTLS
+}
+
+// setClientCertHeaders replaces any client-supplied values so backends can trust these headers.
+func setClientCertHeaders(h http.Header, names conf.ClientCertHeadersConf, state *tls.ConnectionState) {
+	for _, name := range []string{names.Subject, names.SANs, names.Fingerprint} {
+		if name != "" {
+			h.Del(name)
+		}
+	}
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return
+	}
+	cert := state.VerifiedChains[0][0]
+	if names.Subject != "" {
+		h.Set(names.Subject, cert.Subject.String())
+	}
+	if names.SANs != "" {
+		sans := append([]string{}, cert.DNSNames...)
+		sans = append(sans, cert.EmailAddresses...)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+		h.Set(names.SANs, strings.Join(sans, ","))
+	}
+	if names.Fingerprint != "" {
+		sum := sha256.Sum256(cert.Raw)
+		h.Set(names.Fingerprint, hex.EncodeToString(sum[:]))
+	}
+}
+
+func hasVerifiedClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
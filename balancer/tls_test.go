@@ -0,0 +1,297 @@
+package balancer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	path := filepath.Join(dir, "ca.crt")
+	writePEM(t, path, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+// issue writes a leaf certificate for name signed by the CA and returns the cert and key paths.
+func (ca *testCA) issue(t *testing.T, dir, name string, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create cert: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestPortTLSConfig_SelectsCertificateBySNI(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	aCert, aKey := ca.issue(t, dir, "a.example.com", time.Now().Add(time.Hour))
+	bCert, bKey := ca.issue(t, dir, "b.example.com", time.Now().Add(time.Hour))
+	clientCert, clientKey := ca.issue(t, dir, "client", time.Now().Add(time.Hour))
+
+	b := newTestBalancer(t)
+	b.conf.Proxies = []conf.ProxyConf{
+		{Port: 8443, Host: "a.example.com", TLS: conf.TLSOn, Certificate: aCert, CertificateKey: aKey, ClientCA: ca.path},
+		{Port: 8443, Host: "b.example.com", TLS: conf.TLSOn, Certificate: bCert, CertificateKey: bKey, ClientCA: ca.path, MinTLSVersion: "1.3"},
+	}
+	for _, proxy := range b.conf.Proxies {
+		if err := b.registerProxy(proxy); err != nil {
+			t.Fatalf("failed to register proxy: %v", err)
+		}
+	}
+	serverConfig, err := b.portTLSConfig(8443, b.hostRouter[8443])
+	if err != nil {
+		t.Fatalf("portTLSConfig failed: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client, _ := tls.LoadX509KeyPair(clientCert, clientKey)
+	tests := []struct {
+		serverName string
+		wantCN     string
+		maxVersion uint16
+		wantErr    bool
+	}{
+		{"a.example.com", "a.example.com", 0, false},
+		{"b.example.com", "b.example.com", 0, false},
+		{"a.example.com", "a.example.com", tls.VersionTLS12, false},
+		{"b.example.com", "", tls.VersionTLS12, true},
+	}
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			ServerName:   tt.serverName,
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{client},
+			MaxVersion:   tt.maxVersion,
+		})
+		if tt.wantErr {
+			if err == nil {
+				conn.Close()
+				t.Errorf("%s: expected handshake failure below min_tls_version", tt.serverName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: handshake failed: %v", tt.serverName, err)
+		}
+		if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != tt.wantCN {
+			t.Errorf("%s: expected certificate %s, got %s", tt.serverName, tt.wantCN, cn)
+		}
+		conn.Close()
+	}
+}
+
+func TestPortTLSConfig_RejectsMixedPort(t *testing.T) {
+	b := newTestBalancer(t)
+	b.conf.Proxies = []conf.ProxyConf{
+		{Port: 8443, Host: "a.example.com", TLS: conf.TLSOn},
+		{Port: 8443, Host: "b.example.com"},
+	}
+	if _, err := b.portTLSConfig(8443, newHostMatcher()); err == nil {
+		t.Error("expected error when TLS and plain proxies share a port")
+	}
+}
+
+func TestClientAuthMode(t *testing.T) {
+	tests := []struct {
+		proxy   conf.ProxyConf
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{conf.ProxyConf{}, tls.NoClientCert, false},
+		{conf.ProxyConf{ClientCA: "ca.crt"}, tls.RequireAndVerifyClientCert, false},
+		{conf.ProxyConf{ClientAuth: conf.ClientAuthRequest}, tls.RequestClientCert, false},
+		{conf.ProxyConf{ClientAuth: conf.ClientAuthVerifyIfGiven, ClientCA: "ca.crt"}, tls.VerifyClientCertIfGiven, false},
+		{conf.ProxyConf{ClientAuth: conf.ClientAuthVerifyIfGiven}, 0, true},
+		{conf.ProxyConf{ClientAuth: "sometimes"}, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := clientAuthMode(tt.proxy)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.proxy, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%+v: expected %v, got %v", tt.proxy, tt.want, got)
+		}
+	}
+}
+
+func TestProxyRequest_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	clientCert, _ := ca.issue(t, dir, "client.example.com", time.Now().Add(time.Hour))
+	pemBytes, _ := os.ReadFile(clientCert)
+	block, _ := pem.Decode(pemBytes)
+	leaf, _ := x509.ParseCertificate(block.Bytes)
+
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	loc := conf.LocationConf{Path: "/", Algorithm: string(algs.RoundRobin), BackendServers: []conf.BackendServer{{Host: u.Hostname(), Port: port}}}
+	alg, _ := algs.NewAlgorithm(&loc, nil)
+
+	b := newTestBalancer(t)
+	handler := &routeHandler{
+		Path:              "/",
+		Alg:               alg,
+		Transport:         http.DefaultTransport,
+		Admission:         newAdmission(&loc, alg),
+		RequireClientCert: true,
+		ClientCertHeaders: conf.ClientCertHeadersConf{Subject: "X-Client-Subject", SANs: "X-Client-SANs", Fingerprint: "X-Client-Fingerprint"},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	b.proxyRequest(w, r, "example.com", "/", handler, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a verified certificate, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Client-Subject", "CN=forged")
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca.cert}}}
+	w = httptest.NewRecorder()
+	b.proxyRequest(w, r, "example.com", "/", handler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a verified certificate, got %d", w.Code)
+	}
+	if subject := got.Get("X-Client-Subject"); subject != "CN=client.example.com" {
+		t.Errorf("expected subject CN=client.example.com, got %q", subject)
+	}
+	if sans := got.Get("X-Client-SANs"); sans != "client.example.com" {
+		t.Errorf("expected SANs client.example.com, got %q", sans)
+	}
+	if fp := got.Get("X-Client-Fingerprint"); len(fp) != 64 {
+		t.Errorf("expected a sha256 hex fingerprint, got %q", fp)
+	}
+}
+
+func TestRouteRequest_RejectsHandshakeForAnotherHost(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	aCert, aKey := ca.issue(t, dir, "a.example.com", time.Now().Add(time.Hour))
+	bCert, bKey := ca.issue(t, dir, "b.example.com", time.Now().Add(time.Hour))
+
+	b := newTestBalancer(t)
+	b.conf.Proxies = []conf.ProxyConf{
+		{Port: 8443, Host: "a.example.com", TLS: conf.TLSOn, Certificate: aCert, CertificateKey: aKey},
+		{Port: 8443, Host: "b.example.com", TLS: conf.TLSOn, Certificate: bCert, CertificateKey: bKey, ClientCA: ca.path, ClientAuth: conf.ClientAuthRequireAndVerify},
+	}
+	for _, proxy := range b.conf.Proxies {
+		if err := b.registerProxy(proxy); err != nil {
+			t.Fatalf("failed to register proxy: %v", err)
+		}
+	}
+
+	tests := []struct {
+		host, serverName string
+		misdirected      bool
+	}{
+		{"b.example.com", "b.example.com", false},
+		{"b.example.com", "a.example.com", true},
+		// without SNI the handshake falls back to a.example.com, which does not ask for a client certificate
+		{"b.example.com", "", true},
+		{"a.example.com", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tt.host
+		r.TLS = &tls.ConnectionState{ServerName: tt.serverName}
+		w := httptest.NewRecorder()
+		b.routeRequest(w, r, 8443, b.hostRouter[8443])
+		if got := w.Code == http.StatusMisdirectedRequest; got != tt.misdirected {
+			t.Errorf("host %q with SNI %q: expected misdirected %v, got status %d", tt.host, tt.serverName, tt.misdirected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultUDPSessionTimeout = 30 * time.Second
+	maxDatagramSize          = 64 * 1024
+)
+
+// udpProxy forwards datagrams to backends chosen by the pool. Each client address gets a session
+// with its own upstream socket, so replies can be matched back to the client; sessions expire
+// after idle_timeout without traffic in either direction.
+type udpProxy struct {
+	b           *Balancer
+	port        int
+	pool        *streamPool
+	idleTimeout time.Duration
+	mu          sync.Mutex
+	sessions    map[string]*udpSession
+}
+
+type udpSession struct {
+	client       *net.UDPAddr
+	server       algs.IBackendServer
+	upstream     *net.UDPConn
+	lastActivity atomic.Int64
+	sent         atomic.Int64
+	received     atomic.Int64
+}
+
+func (b *Balancer) registerUDPProxy(proxy conf.ProxyConf) error {
+	if _, exists := b.udpProxies[proxy.Port]; exists {
+		return fmt.Errorf("port %d already has a udp proxy", proxy.Port)
+	}
+	pool, err := b.newStreamPool(proxy)
+	if err != nil {
+		return err
+	}
+	idleTimeout := proxy.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUDPSessionTimeout
+	}
+	b.udpProxies[proxy.Port] = &udpProxy{
+		b:           b,
+		port:        proxy.Port,
+		pool:        pool,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*udpSession),
+	}
+	return nil
+}
+
+func (p *udpProxy) listenAndServe() {
+	addr := fmt.Sprintf(":%d", p.port)
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		p.b.logger.Error(fmt.Sprintf("Failed to listen on udp port %d: %v", p.port, err))
+		return
+	}
+	p.b.trackListener(conn)
+	p.b.logger.Info(fmt.Sprintf("Listening in udp mode on %s", addr))
+	p.serve(conn.(*net.UDPConn))
+}
+
+func (p *udpProxy) serve(conn *net.UDPConn) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.b.logger.Error(fmt.Sprintf("UDP read error on port %d: %v", p.port, err))
+			continue
+		}
+		session, err := p.session(conn, client)
+		if err != nil {
+			p.b.logger.Warn(fmt.Sprintf("[%s] dropped datagram from %s: %v", p.pool.name, client, err))
+			continue
+		}
+		session.lastActivity.Store(time.Now().UnixNano())
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			p.b.logger.Warn(fmt.Sprintf("[%s] failed to forward datagram to %s: %v", p.pool.name, session.upstream.RemoteAddr(), err))
+			continue
+		}
+		session.sent.Add(int64(n))
+	}
+}
+
+// session returns the client's session, opening one on a backend picked by client IP so the
+// Hash algorithm keeps a source address on the same backend across sessions.
+func (p *udpProxy) session(conn *net.UDPConn, client *net.UDPAddr) (*udpSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session, exists := p.sessions[client.String()]; exists {
+		return session, nil
+	}
+	server, err := p.pool.admission.acquireNow(client.IP.String())
+	if err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(server.GetUrl())
+	if err != nil {
+		p.pool.admission.release(server)
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", target.Host)
+	if err != nil {
+		p.pool.admission.release(server)
+		return nil, err
+	}
+	upstream, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		p.pool.admission.release(server)
+		return nil, err
+	}
+	session := &udpSession{client: client, server: server, upstream: upstream}
+	session.lastActivity.Store(time.Now().UnixNano())
+	p.sessions[client.String()] = session
+	go p.replies(conn, session)
+	return session, nil
+}
+
+// replies relays backend datagrams to the client until the session has been idle for
+// idleTimeout, then closes it.
+func (p *udpProxy) replies(conn *net.UDPConn, session *udpSession) {
+	defer p.closeSession(session)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		session.upstream.SetReadDeadline(time.Unix(0, session.lastActivity.Load()).Add(p.idleTimeout))
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) && p.active(session) {
+				continue
+			}
+			return
+		}
+		session.lastActivity.Store(time.Now().UnixNano())
+		if _, err := conn.WriteToUDP(buf[:n], session.client); err != nil {
+			p.b.logger.Warn(fmt.Sprintf("[%s] failed to reply to %s: %v", p.pool.name, session.client, err))
+			continue
+		}
+		session.received.Add(int64(n))
+	}
+}
+
+func (p *udpProxy) active(session *udpSession) bool {
+	return time.Since(time.Unix(0, session.lastActivity.Load())) < p.idleTimeout
+}
+
+func (p *udpProxy) closeSession(session *udpSession) {
+	p.mu.Lock()
+	delete(p.sessions, session.client.String())
+	p.mu.Unlock()
+	session.upstream.Close()
+	p.pool.admission.release(session.server)
+	p.b.logger.Info(fmt.Sprintf("[%s] udp session %s -> %s expired, %d bytes sent, %d bytes received",
+		p.pool.name, session.client, session.upstream.RemoteAddr(), session.sent.Load(), session.received.Load()))
+}
@@ -0,0 +1,107 @@
+package balancer
+
+import (
+	"fmt"
+	"load-balancer/conf"
+	"net"
+	"testing"
+	"time"
+)
+
+func newUDPEchoBackend(t *testing.T, name string) conf.BackendServer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP([]byte(fmt.Sprintf("%s:%s", name, buf[:n])), addr)
+		}
+	}()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return conf.BackendServer{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func TestUDPProxy_SessionsAndStickiness(t *testing.T) {
+	b := newTestBalancer(t)
+	proxy := conf.ProxyConf{
+		Port:           5353,
+		Mode:           conf.ModeUDP,
+		Algorithm:      "Hash",
+		IdleTimeout:    200 * time.Millisecond,
+		BackendServers: []conf.BackendServer{newUDPEchoBackend(t, "a"), newUDPEchoBackend(t, "b"), newUDPEchoBackend(t, "c")},
+	}
+	if err := b.registerProxy(proxy); err != nil {
+		t.Fatalf("failed to register proxy: %v", err)
+	}
+	if err := b.registerProxy(proxy); err == nil {
+		t.Error("expected a second udp proxy on the same port to be rejected")
+	}
+	udp := b.udpProxies[5353]
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go udp.serve(ln)
+
+	exchange := func(client *net.UDPConn, msg string) string {
+		t.Helper()
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		buf := make([]byte, 1024)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("no reply for %s: %v", msg, err)
+		}
+		return string(buf[:n])
+	}
+
+	// every client shares 127.0.0.1, so source IP hashing pins them all to one backend
+	var backend string
+	for i := 0; i < 3; i++ {
+		client, err := net.DialUDP("udp", nil, ln.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer client.Close()
+		for j := 0; j < 2; j++ {
+			reply := exchange(client, fmt.Sprintf("ping-%d-%d", i, j))
+			if backend == "" {
+				backend = reply[:1]
+			}
+			if want := fmt.Sprintf("%s:ping-%d-%d", backend, i, j); reply != want {
+				t.Errorf("expected %q, got %q", want, reply)
+			}
+		}
+	}
+	udp.mu.Lock()
+	sessions := len(udp.sessions)
+	udp.mu.Unlock()
+	if sessions != 3 {
+		t.Errorf("expected a session per client address, got %d", sessions)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		udp.mu.Lock()
+		sessions = len(udp.sessions)
+		udp.mu.Unlock()
+		if sessions == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if sessions != 0 {
+		t.Errorf("expected idle sessions to expire, %d left", sessions)
+	}
+}
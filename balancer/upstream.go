@@ -0,0 +1,73 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"load-balancer/conf"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+func newUpstreamTransport(loc *conf.LocationConf) (http.RoundTripper, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	switch loc.SendProxyProtocol {
+	case "":
+	case proxyProtocolV1, proxyProtocolV2:
+		// the header describes a single client, so upstream connections cannot be shared between requests
+		transport.DialContext = proxyProtoDialer(loc.SendProxyProtocol, dialer)
+		transport.DisableKeepAlives = true
+	default:
+		return nil, fmt.Errorf("unsupported send_proxy_protocol %q", loc.SendProxyProtocol)
+	}
+	switch loc.Protocol {
+	case "", conf.ProtocolHTTP:
+	case conf.ProtocolGRPC:
+		var protocols http.Protocols
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = &protocols
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", loc.Protocol)
+	}
+	if loc.UpstreamTLS != nil {
+		tlsConfig, err := newUpstreamTLSConfig(loc.UpstreamTLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+func newUpstreamTLSConfig(c *conf.UpstreamTLSConf) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in upstream CA %s", c.CA)
+		}
+	}
+	if c.Certificate != "" || c.CertificateKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.Certificate, c.CertificateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load upstream client cert/key: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
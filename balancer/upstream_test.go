@@ -0,0 +1,90 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTLS_VerifiesBackendAndPresentsClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "backend.internal", time.Now().Add(time.Hour))
+	clientCert, clientKey := ca.issue(t, dir, "balancer.internal", time.Now().Add(time.Hour))
+
+	pair, _ := tls.LoadX509KeyPair(serverCert, serverKey)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			fmt.Fprint(w, "Pong")
+			return
+		}
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	backend.StartTLS()
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	loc := conf.LocationConf{
+		Path:      "/",
+		Algorithm: string(algs.RoundRobin),
+		Scheme:    "https",
+		UpstreamTLS: &conf.UpstreamTLSConf{
+			CA:             ca.path,
+			ServerName:     "backend.internal",
+			Certificate:    clientCert,
+			CertificateKey: clientKey,
+		},
+		BackendServers: []conf.BackendServer{{Host: u.Hostname(), Port: port}},
+	}
+	transport, err := newUpstreamTransport(&loc)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	alg, err := algs.NewAlgorithm(&loc, transport)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+
+	b := newTestBalancer(t)
+	handler := &routeHandler{Path: "/", Alg: alg, Transport: transport, Admission: newAdmission(&loc, alg)}
+	w := httptest.NewRecorder()
+	b.proxyRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "example.com", "/", handler, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "balancer.internal" {
+		t.Errorf("expected backend to see the balancer's client cert, got %d %q", w.Code, w.Body.String())
+	}
+
+	t.Setenv("RUN_TYPE", "")
+	servers, _ := alg.AllServers()
+	if err := algs.Ping(servers[0]); err != nil {
+		t.Errorf("expected health check over TLS to pass: %v", err)
+	}
+
+	loc.UpstreamTLS = &conf.UpstreamTLSConf{ServerName: "backend.internal"}
+	untrusted, _ := newUpstreamTransport(&loc)
+	alg, _ = algs.NewAlgorithm(&loc, untrusted)
+	servers, _ = alg.AllServers()
+	if err := algs.Ping(servers[0]); err == nil {
+		t.Error("expected health check to fail against an untrusted backend")
+	}
+
+	loc.Scheme = "ftp"
+	if _, err := algs.NewAlgorithm(&loc, nil); err == nil {
+		t.Error("expected unsupported scheme to be rejected")
+	}
+}
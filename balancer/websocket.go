@@ -0,0 +1,249 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// closeGoingAway is a WebSocket close frame with status 1001, sent when the balancer ends an
+// upgraded connection because of shutdown or max lifetime.
+var closeGoingAway = []byte{0x88, 0x02, 0x03, 0xe9}
+
+// upgradeType returns the lowercased protocol of an HTTP Upgrade request, or "" for regular
+// requests.
+func upgradeType(r *http.Request) string {
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return strings.ToLower(r.Header.Get("Upgrade"))
+			}
+		}
+	}
+	return ""
+}
+
+// upgradeWriter hands the reverse proxy a tracked connection when it hijacks the client for a
+// protocol switch.
+type upgradeWriter struct {
+	http.ResponseWriter
+	b           *Balancer
+	websocket   bool
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	// onHijack runs once the backend has accepted the upgrade and the session begins.
+	onHijack func()
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.onHijack != nil {
+		w.onHijack()
+	}
+	return w.b.trackUpgrade(conn, w.websocket, w.idleTimeout, w.maxLifetime), brw, nil
+}
+
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// upgradedConn is the client side of an upgraded connection. Reads and writes extend a shared
+// idle deadline, and for WebSockets the server-to-client stream is followed frame by frame so a
+// close frame can be injected between frames when the connection is drained.
+type upgradedConn struct {
+	net.Conn
+	b            *Balancer
+	websocket    bool
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+	lifetime     *time.Timer
+	closeOnce    sync.Once
+
+	mu        sync.Mutex
+	frames    frameBoundary
+	draining  bool
+	closeSent bool
+}
+
+func (b *Balancer) trackUpgrade(conn net.Conn, websocket bool, idleTimeout, maxLifetime time.Duration) *upgradedConn {
+	c := &upgradedConn{Conn: conn, b: b, websocket: websocket, idleTimeout: idleTimeout}
+	c.lastActivity.Store(time.Now().UnixNano())
+	b.upgradesMu.Lock()
+	b.upgrades[c] = struct{}{}
+	b.upgradesMu.Unlock()
+	if maxLifetime > 0 {
+		c.lifetime = time.AfterFunc(maxLifetime, c.drain)
+	}
+	return c
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	for {
+		if c.idleTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Unix(0, c.lastActivity.Load()).Add(c.idleTimeout))
+		}
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.lastActivity.Store(time.Now().UnixNano())
+		}
+		if n == 0 && errors.Is(err, os.ErrDeadlineExceeded) &&
+			time.Since(time.Unix(0, c.lastActivity.Load())) < c.idleTimeout {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return 0, net.ErrClosed
+	}
+	if c.idleTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.idleTimeout))
+	}
+	n, err := c.Conn.Write(p)
+	c.lastActivity.Store(time.Now().UnixNano())
+	if c.websocket {
+		c.frames.advance(p[:n])
+	}
+	if c.draining {
+		c.sendCloseLocked()
+	}
+	return n, err
+}
+
+// drain ends the connection: WebSockets get a going-away close frame at the next frame
+// boundary, other protocols are closed right away.
+func (c *upgradedConn) drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	if !c.websocket {
+		c.Conn.Close()
+		return
+	}
+	c.sendCloseLocked()
+}
+
+func (c *upgradedConn) sendCloseLocked() {
+	if c.closeSent || !c.frames.atBoundary() {
+		return
+	}
+	c.closeSent = true
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.Conn.Write(closeGoingAway)
+	c.Conn.Close()
+}
+
+func (c *upgradedConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.lifetime != nil {
+			c.lifetime.Stop()
+		}
+		c.b.upgradesMu.Lock()
+		delete(c.b.upgrades, c)
+		c.b.upgradesMu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// drainUpgrades drains every upgraded connection and waits for them to close, forcing the rest
+// closed once ctx is done.
+func (b *Balancer) drainUpgrades(ctx context.Context) {
+	b.upgradesMu.Lock()
+	conns := make([]*upgradedConn, 0, len(b.upgrades))
+	for c := range b.upgrades {
+		conns = append(conns, c)
+	}
+	b.upgradesMu.Unlock()
+	for _, c := range conns {
+		c.drain()
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		b.upgradesMu.Lock()
+		remaining := len(b.upgrades)
+		b.upgradesMu.Unlock()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			for _, c := range conns {
+				c.Conn.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// frameBoundary follows WebSocket frame headers (RFC 6455 section 5.2) in a byte stream to know
+// when the stream sits between two frames.
+type frameBoundary struct {
+	header    []byte
+	remaining uint64
+}
+
+func (f *frameBoundary) advance(p []byte) {
+	for len(p) > 0 {
+		if f.remaining > 0 {
+			n := min(uint64(len(p)), f.remaining)
+			f.remaining -= n
+			p = p[n:]
+			continue
+		}
+		f.header = append(f.header, p[0])
+		p = p[1:]
+		if size, payload, ok := parseFrameHeader(f.header); ok && len(f.header) == size {
+			f.remaining = payload
+			f.header = f.header[:0]
+		}
+	}
+}
+
+func (f *frameBoundary) atBoundary() bool {
+	return f.remaining == 0 && len(f.header) == 0
+}
+
+// parseFrameHeader returns the header size and payload length once enough bytes are known.
+func parseFrameHeader(h []byte) (int, uint64, bool) {
+	if len(h) < 2 {
+		return 0, 0, false
+	}
+	size := 2
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4
+	}
+	if len(h) < size {
+		return size, 0, false
+	}
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(h[2:10])
+	}
+	return size, length, true
+}
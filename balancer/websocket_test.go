@@ -0,0 +1,225 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"load-balancer/algs"
+	"load-balancer/conf"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameBoundary(t *testing.T) {
+	frame := func(payload int, masked bool) []byte {
+		var h []byte
+		switch {
+		case payload < 126:
+			h = []byte{0x81, byte(payload)}
+		case payload < 1<<16:
+			h = []byte{0x81, 126, byte(payload >> 8), byte(payload)}
+		default:
+			h = []byte{0x81, 127, 0, 0, 0, 0, byte(payload >> 24), byte(payload >> 16), byte(payload >> 8), byte(payload)}
+		}
+		if masked {
+			h[1] |= 0x80
+			h = append(h, 1, 2, 3, 4)
+		}
+		return append(h, make([]byte, payload)...)
+	}
+	stream := bytes.Join([][]byte{frame(5, false), frame(300, true), frame(70000, false)}, nil)
+	boundaries := map[int]bool{0: true, 7: true, 7 + 308: true, len(stream): true}
+
+	var f frameBoundary
+	for i := 0; i < len(stream); i++ {
+		if got := f.atBoundary(); got != boundaries[i] {
+			t.Fatalf("offset %d: expected boundary %v, got %v", i, boundaries[i], got)
+		}
+		f.advance(stream[i : i+1])
+	}
+	if !f.atBoundary() {
+		t.Error("expected a boundary at the end of the stream")
+	}
+}
+
+func newWebSocketBackend(t *testing.T, serve func(conn net.Conn)) (conf.LocationConf, algs.IAlgorithm) {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		serve(conn)
+	}))
+	t.Cleanup(backend.Close)
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+	loc := conf.LocationConf{Path: "/", Algorithm: string(algs.LeastConnections), BackendServers: []conf.BackendServer{{Host: u.Hostname(), Port: port}}}
+	alg, err := algs.NewAlgorithm(&loc, nil)
+	if err != nil {
+		t.Fatalf("failed to create algorithm: %v", err)
+	}
+	return loc, alg
+}
+
+func dialWebSocket(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %v, error: %v", resp, err)
+	}
+	return conn, reader
+}
+
+func TestWebSocket_DrainSendsCloseFrameBetweenFrames(t *testing.T) {
+	halfSent := make(chan struct{})
+	resume := make(chan struct{})
+	loc, alg := newWebSocketBackend(t, func(conn net.Conn) {
+		conn.Write([]byte{0x81, 0x05, 'h', 'e'})
+		close(halfSent)
+		<-resume
+		conn.Write([]byte{'l', 'l', 'o'})
+		io.Copy(io.Discard, conn)
+	})
+	b := newTestBalancer(t)
+	handler := &routeHandler{Path: "/", Alg: alg, Transport: http.DefaultTransport, Admission: newAdmission(&loc, alg)}
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+	}))
+	defer front.Close()
+
+	conn, reader := dialWebSocket(t, front.Listener.Addr().String())
+	defer conn.Close()
+	<-halfSent
+	servers, _ := alg.AllServers()
+	if active := servers[0].ActiveConnections(); active != 1 {
+		t.Errorf("expected the upgraded connection to count as active, got %d", active)
+	}
+
+	// wait until the partial frame has reached the client before draining
+	partial := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(reader, partial); err != nil {
+		t.Fatalf("failed to read partial frame: %v", err)
+	}
+	drained := make(chan struct{})
+	go func() {
+		b.drainUpgrades(context.Background())
+		close(drained)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(resume)
+
+	rest, _ := io.ReadAll(reader)
+	want := append([]byte{'l', 'l', 'o'}, closeGoingAway...)
+	if !bytes.Equal(rest, want) {
+		t.Errorf("expected the frame to complete before the close frame, got %x", rest)
+	}
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Error("drain did not finish")
+	}
+}
+
+func TestWebSocket_IdleTimeout(t *testing.T) {
+	loc, alg := newWebSocketBackend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	b := newTestBalancer(t)
+	handler := &routeHandler{Path: "/", Alg: alg, Transport: http.DefaultTransport, Admission: newAdmission(&loc, alg), WebSocketIdle: 200 * time.Millisecond}
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+	}))
+	defer front.Close()
+
+	conn, reader := dialWebSocket(t, front.Listener.Addr().String())
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := reader.ReadByte(); err == nil || time.Since(start) > 3*time.Second {
+		t.Errorf("expected the idle connection to be closed, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestWebSocket_LongSessionsKeepAdaptiveLimit(t *testing.T) {
+	loc, alg := newWebSocketBackend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	limiter, err := newAdaptiveLimiter(&conf.AdaptiveConcurrencyConf{InitialLimit: 4, LatencyThreshold: 100 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+	b := newTestBalancer(t)
+	handler := &routeHandler{Path: "/", Alg: alg, Transport: http.DefaultTransport, Admission: newAdmission(&loc, alg), Adaptive: limiter}
+	var wg sync.WaitGroup
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wg.Add(1)
+		defer wg.Done()
+		b.proxyRequest(w, r, "example.com", "/", handler, nil)
+	}))
+	defer front.Close()
+
+	// more sessions than the limit, each far longer than the latency threshold
+	var conns []net.Conn
+	for range 6 {
+		conn, _ := dialWebSocket(t, front.Listener.Addr().String())
+		conns = append(conns, conn)
+	}
+	time.Sleep(300 * time.Millisecond)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	wg.Wait()
+	if got := limiter.currentLimit(); got < 4 {
+		t.Errorf("expected long sessions not to shrink the limit, got %d", got)
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.inflight != 0 {
+		t.Errorf("expected every slot to be given back, %d still in flight", limiter.inflight)
+	}
+}
+
+func TestWebSocket_LocationEnforcement(t *testing.T) {
+	b := newTestBalancer(t)
+	required, refused := true, false
+	upgrade := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		return r
+	}
+	tests := []struct {
+		websocket *bool
+		r         *http.Request
+		want      int
+	}{
+		{&required, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusUpgradeRequired},
+		{&refused, upgrade(), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		b.proxyRequest(w, tt.r, "example.com", "/", &routeHandler{Path: "/", WebSocket: tt.websocket}, nil)
+		if w.Code != tt.want {
+			t.Errorf("websocket %v: expected %d, got %d", *tt.websocket, tt.want, w.Code)
+		}
+	}
+}
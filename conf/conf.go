@@ -1,6 +1,13 @@
 package conf
 
 import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
@@ -16,28 +23,257 @@ type Conf struct {
 	Proxies []ProxyConf `mapstructure:"proxies"`
 	Log     LogConf     `mapstructure:"log"`
 	Kafka   KafkaConf   `mapstructure:"kafka"`
+
+	RateLimitStore RateLimitStoreConf `mapstructure:"rate_limit_store"`
+	Metrics        MetricsConf        `mapstructure:"metrics"`
+	ACME           ACMEConf           `mapstructure:"acme"`
+
+	UnmatchedHostStatus int           `mapstructure:"unmatched_host_status"`
+	CertReloadInterval  time.Duration `mapstructure:"cert_reload_interval"`
+	ShutdownTimeout     time.Duration `mapstructure:"shutdown_timeout"`
+	Zone                string        `mapstructure:"zone"`
+}
+
+type MetricsConf struct {
+	Port int    `mapstructure:"port"`
+	Path string `mapstructure:"path"`
 }
 
 type ProxyConf struct {
-	Port           int            `mapstructure:"port"`
-	Host           string         `mapstructure:"host"`
-	TLS            bool           `mapstructure:"tls"`
-	Certificate    string         `mapstructure:"certificate"`
-	CertificateKey string         `mapstructure:"certificate_key"`
-	ClientCA       string         `mapstructure:"certificate_ca"`
-	Locations      []LocationConf `mapstructure:"locations"`
+	Port              int                   `mapstructure:"port"`
+	Host              string                `mapstructure:"host"`
+	Default           bool                  `mapstructure:"default"`
+	Mode              ProxyMode             `mapstructure:"mode"`
+	TLS               TLSMode               `mapstructure:"tls"`
+	Certificate       string                `mapstructure:"certificate"`
+	CertificateKey    string                `mapstructure:"certificate_key"`
+	ClientCA          string                `mapstructure:"certificate_ca"`
+	MinTLSVersion     string                `mapstructure:"min_tls_version"`
+	ClientAuth        ClientAuthMode        `mapstructure:"client_auth"`
+	ClientCertHeaders ClientCertHeadersConf `mapstructure:"client_cert_headers"`
+	ProxyProtocol     bool                  `mapstructure:"proxy_protocol"`
+	HTTP3             bool                  `mapstructure:"http3"`
+	RateLimit         *RateLimitConf        `mapstructure:"rate_limit"`
+	Locations         []LocationConf        `mapstructure:"locations"`
+	Algorithm         string                `mapstructure:"algorithm"`
+	BackendServers    []BackendServer       `mapstructure:"backend_servers"`
+	ConnectTimeout    time.Duration         `mapstructure:"connect_timeout"`
+	IdleTimeout       time.Duration         `mapstructure:"idle_timeout"`
+}
+
+// ProxyMode selects how a port is served. Stream modes balance whole connections across the
+// proxy's own backend_servers instead of routing requests through locations.
+type ProxyMode string
+
+const (
+	ModeHTTP           ProxyMode = "http"
+	ModeTLSPassthrough ProxyMode = "tls_passthrough"
+	ModeTCP            ProxyMode = "tcp"
+	ModeUDP            ProxyMode = "udp"
+)
+
+func (m ProxyMode) IsStream() bool {
+	return m == ModeTLSPassthrough || m == ModeTCP
+}
+
+// TLSMode is off, on (certificate files) or auto (certificates issued over ACME). The original
+// boolean form is still accepted.
+type TLSMode string
+
+const (
+	TLSOff  TLSMode = "off"
+	TLSOn   TLSMode = "on"
+	TLSAuto TLSMode = "auto"
+)
+
+func (m TLSMode) Enabled() bool {
+	return m == TLSOn || m == TLSAuto
+}
+
+type ACMEConf struct {
+	DirectoryURL      string        `mapstructure:"directory_url"`
+	DirectoryCA       string        `mapstructure:"directory_ca"`
+	Email             string        `mapstructure:"email"`
+	CacheDir          string        `mapstructure:"cache_dir"`
+	HTTPChallengePort int           `mapstructure:"http_challenge_port"`
+	RenewBefore       time.Duration `mapstructure:"renew_before"`
+}
+
+type ClientAuthMode string
+
+const (
+	ClientAuthNone             ClientAuthMode = "none"
+	ClientAuthRequest          ClientAuthMode = "request"
+	ClientAuthRequire          ClientAuthMode = "require"
+	ClientAuthVerifyIfGiven    ClientAuthMode = "verify_if_given"
+	ClientAuthRequireAndVerify ClientAuthMode = "require_and_verify"
+)
+
+type ClientCertHeadersConf struct {
+	Subject     string `mapstructure:"subject"`
+	SANs        string `mapstructure:"sans"`
+	Fingerprint string `mapstructure:"fingerprint"`
 }
 
 type LocationConf struct {
-	Path           string          `mapstructure:"path"`
+	Path                string                   `mapstructure:"path"`
+	Algorithm           string                   `mapstructure:"algorithm"`
+	SendProxyProtocol   string                   `mapstructure:"send_proxy_protocol"`
+	RateLimit           *RateLimitConf           `mapstructure:"rate_limit"`
+	MaxConnections      int                      `mapstructure:"max_connections"`
+	MaxPending          int                      `mapstructure:"max_pending"`
+	QueueTimeout        time.Duration            `mapstructure:"queue_timeout"`
+	AdaptiveConcurrency *AdaptiveConcurrencyConf `mapstructure:"adaptive_concurrency"`
+	Rewrite             string                   `mapstructure:"rewrite"`
+	SetHeaders          map[string]string        `mapstructure:"set_headers"`
+	HashKey             string                   `mapstructure:"hash_key"`
+	Match               *MatchConf               `mapstructure:"match"`
+	RequireClientCert   bool                     `mapstructure:"require_client_cert"`
+	Scheme              string                   `mapstructure:"scheme"`
+	Protocol            UpstreamProtocol         `mapstructure:"protocol"`
+	HealthCheck         HealthCheckType          `mapstructure:"health_check"`
+	UpstreamTLS         *UpstreamTLSConf         `mapstructure:"upstream_tls"`
+	WebSocket           *bool                    `mapstructure:"websocket"`
+	WebSocketIdle       time.Duration            `mapstructure:"websocket_idle_timeout"`
+	WebSocketLifetime   time.Duration            `mapstructure:"websocket_max_lifetime"`
+	Overflow            bool                     `mapstructure:"overflow"`
+	Zone                string                   `mapstructure:"zone"`
+	ZoneMinAvailable    float64                  `mapstructure:"zone_min_available_percent"`
+	Sticky              *StickyConf              `mapstructure:"sticky"`
+	Split               *SplitConf               `mapstructure:"split"`
+	Groups              []BackendGroupConf       `mapstructure:"groups"`
+	Mirror              *MirrorConf              `mapstructure:"mirror"`
+	BackendServers      []BackendServer          `mapstructure:"backend_servers"`
+}
+
+// BackendGroupConf is a named set of backends with its own algorithm, e.g. stable and canary.
+type BackendGroupConf struct {
+	Name           string          `mapstructure:"name"`
+	Weight         int             `mapstructure:"weight"`
+	Algorithm      string          `mapstructure:"algorithm"`
+	BackendServers []BackendServer `mapstructure:"backend_servers"`
+}
+
+// MirrorConf copies Percent of a location's requests to a shadow pool and discards its responses.
+type MirrorConf struct {
+	Percent        float64         `mapstructure:"percent"`
+	MaxBodySize    int64           `mapstructure:"max_body_size"`
+	MaxConnections int             `mapstructure:"max_connections"`
+	Header         string          `mapstructure:"header"`
+	Timeout        time.Duration   `mapstructure:"timeout"`
 	Algorithm      string          `mapstructure:"algorithm"`
 	BackendServers []BackendServer `mapstructure:"backend_servers"`
 }
 
+// SplitConf picks a group by header or cookie, then by a hash of the user ID, then by weight.
+type SplitConf struct {
+	Header     string `mapstructure:"header"`
+	Cookie     string `mapstructure:"cookie"`
+	HashHeader string `mapstructure:"hash_header"`
+	HashCookie string `mapstructure:"hash_cookie"`
+}
+
+// UpstreamProtocol grpc speaks HTTP/2 to backends: h2c for http and ALPN h2 for https.
+type UpstreamProtocol string
+
+const (
+	ProtocolHTTP UpstreamProtocol = "http"
+	ProtocolGRPC UpstreamProtocol = "grpc"
+)
+
+type HealthCheckType string
+
+const (
+	HealthCheckHTTP HealthCheckType = "http"
+	HealthCheckGRPC HealthCheckType = "grpc"
+)
+
+type UpstreamTLSConf struct {
+	CA                 string `mapstructure:"ca"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	Certificate        string `mapstructure:"certificate"`
+	CertificateKey     string `mapstructure:"certificate_key"`
+}
+
+// StickyConf pins clients to a backend with a cookie or header, HMAC-signed when Secret is set.
+type StickyConf struct {
+	Cookie   string        `mapstructure:"cookie"`
+	Header   string        `mapstructure:"header"`
+	Secret   string        `mapstructure:"secret"`
+	TTL      time.Duration `mapstructure:"ttl"`
+	Path     string        `mapstructure:"path"`
+	Secure   bool          `mapstructure:"secure"`
+	HTTPOnly bool          `mapstructure:"http_only"`
+	SameSite string        `mapstructure:"same_site"`
+}
+
+type AdaptiveConcurrencyConf struct {
+	Algorithm        string        `mapstructure:"algorithm"`
+	InitialLimit     int           `mapstructure:"initial_limit"`
+	MinLimit         int           `mapstructure:"min_limit"`
+	MaxLimit         int           `mapstructure:"max_limit"`
+	LatencyThreshold time.Duration `mapstructure:"latency_threshold"`
+	BackoffRatio     float64       `mapstructure:"backoff_ratio"`
+	Smoothing        float64       `mapstructure:"smoothing"`
+}
+
+type MatchConf struct {
+	Methods []string         `mapstructure:"methods"`
+	Headers []ValueMatchConf `mapstructure:"headers"`
+	Query   []ValueMatchConf `mapstructure:"query"`
+	Cookies []ValueMatchConf `mapstructure:"cookies"`
+}
+
+type ValueMatchConf struct {
+	Name   string `mapstructure:"name"`
+	Equals string `mapstructure:"equals"`
+	Regex  string `mapstructure:"regex"`
+}
+
+type RateLimitKey string
+
+const (
+	RateLimitByIP       RateLimitKey = "ip"
+	RateLimitByHeader   RateLimitKey = "header"
+	RateLimitByAPIKey   RateLimitKey = "api_key"
+	RateLimitByJWTClaim RateLimitKey = "jwt_claim"
+)
+
+type RateLimitConf struct {
+	RequestsPerSecond float64      `mapstructure:"requests_per_second"`
+	Burst             int          `mapstructure:"burst"`
+	Key               RateLimitKey `mapstructure:"key"`
+	Header            string       `mapstructure:"header"`
+	Claim             string       `mapstructure:"claim"`
+}
+
+// BackendServer tiers are tried by ascending Priority, with Backup servers last.
 type BackendServer struct {
-	Host   string `mapstructure:"host"`
-	Port   int    `mapstructure:"port"`
-	Weight int    `mapstructure:"weight"`
+	Scheme         string `mapstructure:"scheme"`
+	Host           string `mapstructure:"host"`
+	Port           int    `mapstructure:"port"`
+	Weight         int    `mapstructure:"weight"`
+	MaxConnections int    `mapstructure:"max_connections"`
+	MaxPending     int    `mapstructure:"max_pending"`
+	Priority       int    `mapstructure:"priority"`
+	Backup         bool   `mapstructure:"backup"`
+	Zone           string `mapstructure:"zone"`
+}
+
+type RateLimitStoreType string
+
+const (
+	MemoryStore RateLimitStoreType = "memory"
+	RedisStore  RateLimitStoreType = "redis"
+)
+
+type RateLimitStoreConf struct {
+	Type     RateLimitStoreType `mapstructure:"type"`
+	Address  string             `mapstructure:"address"`
+	Password string             `mapstructure:"password"`
+	DB       int                `mapstructure:"db"`
+	Timeout  time.Duration      `mapstructure:"timeout"`
 }
 
 type LogConf struct {
@@ -55,13 +291,64 @@ func ReadConf() (*Conf, error) {
 	v.SetConfigName("config")
 	v.SetConfigType("yaml")
 	v.AddConfigPath(".")
+	v.OnConfigChange(func(fsnotify.Event) {
+		conf := &Conf{}
+		err := v.Unmarshal(conf, viper.DecodeHook(decodeHook))
+		listenersMu.Lock()
+		defer listenersMu.Unlock()
+		for _, fn := range listeners {
+			fn(conf, err)
+		}
+	})
 	v.WatchConfig()
 	if err := v.ReadInConfig(); err != nil {
 		return nil, err
 	}
 	conf := &Conf{}
-	if err := v.Unmarshal(conf); err != nil {
+	if err := v.Unmarshal(conf, viper.DecodeHook(decodeHook)); err != nil {
 		return nil, err
 	}
 	return conf, nil
 }
+
+var (
+	listenersMu sync.Mutex
+	listeners   []func(*Conf, error)
+)
+
+// OnChange registers fn to receive the configuration whenever the file read by ReadConf changes.
+// Only settings that say so are applied at runtime; everything else still needs a restart.
+func OnChange(fn func(*Conf, error)) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// decodeHook extends viper's default hooks with the TLSMode conversion.
+var decodeHook = mapstructure.ComposeDecodeHookFunc(
+	decodeTLSMode,
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+)
+
+func decodeTLSMode(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(TLSMode("")) {
+		return data, nil
+	}
+	switch v := data.(type) {
+	case bool:
+		if v {
+			return TLSOn, nil
+		}
+		return TLSOff, nil
+	case string:
+		switch strings.ToLower(v) {
+		case "true":
+			return TLSOn, nil
+		case "false", "":
+			return TLSOff, nil
+		}
+		return TLSMode(strings.ToLower(v)), nil
+	}
+	return data, nil
+}
@@ -0,0 +1,43 @@
+package conf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestDecodeHook_TLSMode(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(strings.NewReader(`
+proxies:
+  - host: a.example.com
+    tls: true
+  - host: b.example.com
+    tls: false
+  - host: c.example.com
+    tls: auto
+  - host: d.example.com
+    locations:
+      - path: /
+        queue_timeout: 2s
+`))
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	conf := &Conf{}
+	if err := v.Unmarshal(conf, viper.DecodeHook(decodeHook)); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	want := []TLSMode{TLSOn, TLSOff, TLSAuto, ""}
+	for i, proxy := range conf.Proxies {
+		if proxy.TLS != want[i] {
+			t.Errorf("%s: expected tls %q, got %q", proxy.Host, want[i], proxy.TLS)
+		}
+	}
+	if timeout := conf.Proxies[3].Locations[0].QueueTimeout; timeout != 2*time.Second {
+		t.Errorf("expected durations to still decode, got %v", timeout)
+	}
+}
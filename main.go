@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"load-balancer/balancer"
 	"load-balancer/conf"
 	"load-balancer/log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	conf, err := conf.ReadConf()
 	if err != nil {
@@ -20,6 +26,21 @@ func main() {
 		os.Exit(1)
 	}
 	balancer := balancer.NewBalancer(conf, logger)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		timeout := conf.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		logger.Info(fmt.Sprintf("Received %v, shutting down within %s", sig, timeout))
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := balancer.Shutdown(ctx); err != nil {
+			logger.Error(fmt.Errorf("error during shutdown: %v", err))
+		}
+	}()
 	if err := balancer.Start(); err != nil {
 		logger.Error(fmt.Errorf("error starting reverse proxy: %v", err))
 		os.Exit(1)
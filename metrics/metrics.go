@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type kind string
+
+const (
+	counterKind kind = "counter"
+	gaugeKind   kind = "gauge"
+)
+
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+type Gauge struct {
+	Counter
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+type series struct {
+	labels string
+	value  func() float64
+}
+
+type family struct {
+	kind   kind
+	series map[string]*series
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+	}
+}
+
+var Default = NewRegistry()
+
+// Counter returns the counter for name and label pairs, creating it on first use.
+func (r *Registry) Counter(name string, labels ...string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, rendered := seriesKey(name, labels)
+	if c, exists := r.counters[key]; exists {
+		return c
+	}
+	c := &Counter{}
+	r.counters[key] = c
+	r.register(name, counterKind, rendered, c.Value)
+	return c
+}
+
+// Gauge returns the gauge for name and label pairs, creating it on first use.
+func (r *Registry) Gauge(name string, labels ...string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, rendered := seriesKey(name, labels)
+	if g, exists := r.gauges[key]; exists {
+		return g
+	}
+	g := &Gauge{}
+	r.gauges[key] = g
+	r.register(name, gaugeKind, rendered, g.Value)
+	return g
+}
+
+func (r *Registry) register(name string, k kind, labels string, value func() float64) {
+	f, exists := r.families[name]
+	if !exists {
+		f = &family{kind: k, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	f.series[labels] = &series{labels: labels, value: value}
+}
+
+// Handler serves the registry in the Prometheus text exposition format. The exposition is rendered
+// under the lock and written after it is released, so a slow scraper cannot block new series.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(r.render())
+	})
+}
+
+func (r *Registry) render() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			fmt.Fprintf(&buf, "%s%s %v\n", name, labels, f.series[labels].value())
+		}
+	}
+	return buf.Bytes()
+}
+
+func seriesKey(name string, labels []string) (string, string) {
+	if len(labels) == 0 {
+		return name, ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	rendered := "{" + strings.Join(pairs, ",") + "}"
+	return name + rendered, rendered
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "location", "/api").Inc()
+	r.Counter("requests_total", "location", "/api").Add(2)
+	r.Counter("requests_total", "location", "/").Inc()
+	r.Gauge("limit", "location", "/api").Set(12)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+
+	expected := []string{
+		"# TYPE limit gauge",
+		`limit{location="/api"} 12`,
+		"# TYPE requests_total counter",
+		`requests_total{location="/"} 1`,
+		`requests_total{location="/api"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected line %q in output:\n%s", line, body)
+		}
+	}
+}
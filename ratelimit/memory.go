@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	shardCount          = 32
+	defaultIdleTimeout  = 10 * time.Minute
+	evictionCheckPeriod = time.Minute
+)
+
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	lastSeen time.Time
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type MemoryStore struct {
+	shards      [shardCount]*shard
+	idleTimeout time.Duration
+	ticker      *time.Ticker
+	done        chan struct{}
+	closeOnce   sync.Once
+	now         func() time.Time
+}
+
+func NewMemoryStore(idleTimeout time.Duration) *MemoryStore {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	store := &MemoryStore{
+		idleTimeout: idleTimeout,
+		ticker:      time.NewTicker(evictionCheckPeriod),
+		done:        make(chan struct{}),
+		now:         time.Now,
+	}
+	for i := range store.shards {
+		store.shards[i] = &shard{buckets: make(map[string]*bucket)}
+	}
+	go func() {
+		for {
+			select {
+			case <-store.ticker.C:
+				store.evict()
+			case <-store.done:
+				return
+			}
+		}
+	}()
+	return store
+}
+
+func (m *MemoryStore) shardFor(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%shardCount]
+}
+
+func (m *MemoryStore) Take(key string, limit Limit) (Result, error) {
+	s := m.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	burst := float64(limit.burst())
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	b.lastSeen = now
+
+	result := Result{Limit: limit.burst()}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.ResetAfter = time.Duration((burst - b.tokens) / limit.Rate * float64(time.Second))
+	return result, nil
+}
+
+func (m *MemoryStore) evict() {
+	cutoff := m.now().Add(-m.idleTimeout)
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if b.lastSeen.Before(cutoff) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (m *MemoryStore) Close() {
+	m.closeOnce.Do(func() {
+		m.ticker.Stop()
+		close(m.done)
+	})
+}
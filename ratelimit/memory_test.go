@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		res, err := store.Take("client-a", limit)
+		if err != nil || !res.Allowed {
+			t.Fatalf("request %d should be allowed within burst, got %+v, %v", i, res, err)
+		}
+		if res.Remaining != 2-i {
+			t.Errorf("expected remaining %d, got %d", 2-i, res.Remaining)
+		}
+	}
+
+	res, _ := store.Take("client-a", limit)
+	if res.Allowed {
+		t.Fatal("request beyond burst should be rejected")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("expected retry after 500ms, got %v", res.RetryAfter)
+	}
+
+	if res, _ := store.Take("client-b", limit); !res.Allowed {
+		t.Error("buckets must be isolated per key")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if res, _ := store.Take("client-a", limit); !res.Allowed {
+		t.Error("a token should have been refilled after 500ms")
+	}
+}
+
+func TestMemoryStore_Evict(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	store.Take("idle", Limit{Rate: 1, Burst: 1})
+	now = now.Add(2 * time.Minute)
+	store.Take("active", Limit{Rate: 1, Burst: 1})
+	store.evict()
+
+	count := 0
+	for _, s := range store.shards {
+		count += len(s.buckets)
+		if _, exists := s.buckets["idle"]; exists {
+			t.Error("idle bucket should have been evicted")
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected 1 remaining bucket, got %d", count)
+	}
+}
+
+func TestMemoryStore_CloseStopsEviction(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for range 50 {
+		NewMemoryStore(time.Minute).Close()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected eviction goroutines to exit, %d running before and %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type IStore interface {
+	Take(key string, limit Limit) (Result, error)
+}
+
+func (l Limit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisTimeout = 100 * time.Millisecond
+	redisRetryBackoff   = 5 * time.Second
+	redisKeyPrefix      = "ratelimit:"
+)
+
+// gcraScript implements the generic cell rate algorithm. Redis TIME is used so replicas share one clock.
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+local tolerance = emission * burst
+local new_tat = tat + emission
+local allow_at = new_tat - tolerance
+if now < allow_at then
+  return {0, 0, allow_at - now, tat - now}
+end
+redis.call("SET", KEYS[1], new_tat, "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor((tolerance - (new_tat - now)) / emission), 0, new_tat - now}
+`)
+
+type RedisStore struct {
+	client      redis.UniversalClient
+	fallback    IStore
+	timeout     time.Duration
+	mu          sync.Mutex
+	unavailable time.Time
+}
+
+func NewRedisStore(client redis.UniversalClient, fallback IStore, timeout time.Duration) *RedisStore {
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	return &RedisStore{
+		client:   client,
+		fallback: fallback,
+		timeout:  timeout,
+	}
+}
+
+// Take falls back to local limiting while Redis is unreachable, retrying Redis after a short backoff.
+func (s *RedisStore) Take(key string, limit Limit) (Result, error) {
+	if s.inBackoff() {
+		return s.fallback.Take(key, limit)
+	}
+	res, err := s.take(key, limit)
+	if err != nil {
+		s.mu.Lock()
+		s.unavailable = time.Now()
+		s.mu.Unlock()
+		if s.fallback == nil {
+			return Result{}, err
+		}
+		return s.fallback.Take(key, limit)
+	}
+	return res, nil
+}
+
+func (s *RedisStore) inBackoff() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fallback != nil && time.Since(s.unavailable) < redisRetryBackoff
+}
+
+func (s *RedisStore) take(key string, limit Limit) (Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	emission := float64(time.Second/time.Microsecond) / limit.Rate
+	values, err := gcraScript.Run(ctx, s.client, []string{redisKeyPrefix + key}, emission, limit.burst()).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("redis rate limit script failed: %w", err)
+	}
+	if len(values) != 4 {
+		return Result{}, fmt.Errorf("unexpected redis rate limit reply %v", values)
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit.burst(),
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+		ResetAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
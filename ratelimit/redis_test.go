@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStore_Take(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	store := NewRedisStore(client, nil, time.Second)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		res, err := store.Take("client-a", limit)
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i, 2-i, res)
+		}
+	}
+	res, err := store.Take("client-a", limit)
+	if err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if res.Allowed {
+		t.Fatal("request beyond burst should be rejected")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Errorf("expected retry after within one emission interval, got %v", res.RetryAfter)
+	}
+
+	replica := NewRedisStore(client, nil, time.Second)
+	if res, _ := replica.Take("client-a", limit); res.Allowed {
+		t.Error("a second store sharing redis must see the same bucket")
+	}
+}
+
+func TestRedisStore_FallbackWhenUnreachable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	defer client.Close()
+	fallback := NewMemoryStore(0)
+	defer fallback.Close()
+	store := NewRedisStore(client, fallback, 50*time.Millisecond)
+	server.Close()
+
+	limit := Limit{Rate: 1, Burst: 1}
+	res, err := store.Take("client-a", limit)
+	if err != nil || !res.Allowed {
+		t.Fatalf("expected local fallback to allow the first request, got %+v, %v", res, err)
+	}
+	if res, _ := store.Take("client-a", limit); res.Allowed {
+		t.Error("local fallback should enforce the limit")
+	}
+}